@@ -3,17 +3,21 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azeventhubs"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/viper"
+	"github.com/zdrgeo/bulk-data-collector/pkg/cloudevents"
 	"github.com/zdrgeo/bulk-data-collector/pkg/handlers"
+	"github.com/zdrgeo/bulk-data-collector/pkg/serializers"
 	azureeventhubsservices "github.com/zdrgeo/bulk-data-collector/pkg/services/azureeventhubs"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
@@ -25,9 +29,24 @@ import (
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 )
 
+const (
+	// Azure Event Hubs authentication methods
+	authenticationConnectionString  = "ConnectionString"
+	authenticationDefault           = "Default"
+	authenticationWorkloadIdentity  = "WorkloadIdentity"
+	authenticationManagedIdentity   = "ManagedIdentity"
+	authenticationClientSecret      = "ClientSecret"
+	authenticationClientCertificate = "ClientCertificate"
+	// Azure Event Hubs authentication methods
+)
+
+var (
+	errInvalidAuthentication = errors.New("invalid authentication")
+)
+
 var (
 	logger     *slog.Logger
-	credential *azidentity.DefaultAzureCredential
+	credential azcore.TokenCredential
 )
 
 func init() {
@@ -123,13 +142,83 @@ func initAzureEventHubs() {
 
 	otel.SetMeterProvider(meterProvider)
 
-	credential, err = azidentity.NewDefaultAzureCredential(nil)
+	authentication := viper.GetString("AZURE_EVENTHUBS_AUTHENTICATION")
+
+	if authentication == "" {
+		if viper.GetString("AZURE_EVENTHUBS_CONNECTION_STRING") != "" {
+			authentication = authenticationConnectionString
+		} else {
+			authentication = authenticationDefault
+		}
+	}
+
+	credential, err = newCredential(authentication)
 
 	if err != nil {
 		log.Panic(err)
 	}
 }
 
+// newCredential creates the Microsoft Entra ID credential of the authentication method. There is no credential for the connection string authentication, which uses shared access keys instead.
+func newCredential(authentication string) (azcore.TokenCredential, error) {
+	tenantID := viper.GetString("AZURE_TENANT_ID")
+	clientID := viper.GetString("AZURE_CLIENT_ID")
+
+	switch authentication {
+	case authenticationConnectionString:
+		return nil, nil
+	case authenticationDefault:
+		defaultCredentialOptions := &azidentity.DefaultAzureCredentialOptions{
+			TenantID: tenantID,
+		}
+
+		return azidentity.NewDefaultAzureCredential(defaultCredentialOptions)
+	case authenticationWorkloadIdentity:
+		workloadIdentityCredentialOptions := &azidentity.WorkloadIdentityCredentialOptions{
+			TenantID:      tenantID,
+			ClientID:      clientID,
+			TokenFilePath: viper.GetString("AZURE_FEDERATED_TOKEN_FILE"),
+		}
+
+		return azidentity.NewWorkloadIdentityCredential(workloadIdentityCredentialOptions)
+	case authenticationManagedIdentity:
+		managedIdentityCredentialOptions := &azidentity.ManagedIdentityCredentialOptions{}
+
+		// User-assigned managed identity, otherwise system-assigned managed identity
+		if clientID != "" {
+			managedIdentityCredentialOptions.ID = azidentity.ClientID(clientID)
+		}
+
+		return azidentity.NewManagedIdentityCredential(managedIdentityCredentialOptions)
+	case authenticationClientSecret:
+		return azidentity.NewClientSecretCredential(tenantID, clientID, viper.GetString("AZURE_CLIENT_SECRET"), nil)
+	case authenticationClientCertificate:
+		certificateData, err := os.ReadFile(viper.GetString("AZURE_CLIENT_CERTIFICATE_PATH"))
+
+		if err != nil {
+			return nil, err
+		}
+
+		certificates, key, err := azidentity.ParseCertificates(certificateData, []byte(viper.GetString("AZURE_CLIENT_CERTIFICATE_PASSWORD")))
+
+		if err != nil {
+			return nil, err
+		}
+
+		return azidentity.NewClientCertificateCredential(tenantID, clientID, certificates, key, nil)
+	}
+
+	return nil, errInvalidAuthentication
+}
+
+func newProducerClient(namespace, connectionString, eventHub string) (*azeventhubs.ProducerClient, error) {
+	if credential == nil {
+		return azeventhubs.NewProducerClientFromConnectionString(connectionString, eventHub, nil)
+	}
+
+	return azeventhubs.NewProducerClient(namespace, eventHub, credential, nil)
+}
+
 func main() {
 	mainAzureEventHubs()
 }
@@ -137,21 +226,119 @@ func main() {
 func mainAzureEventHubs() {
 	ctx := context.Background()
 
-	// producerClient, err := azeventhubs.NewProducerClient(viper.GetString("AZURE_EVENTHUBS_NAMESPACE"), viper.GetString("AZURE_EVENTHUBS_EVENTHUB"), credential, nil)
-	producerClient, err := azeventhubs.NewProducerClientFromConnectionString(viper.GetString("AZURE_EVENTHUBS_CONNECTION_STRING"), viper.GetString("AZURE_EVENTHUBS_EVENTHUB"), nil)
+	producerClient, err := newProducerClient(viper.GetString("AZURE_EVENTHUBS_NAMESPACE"), viper.GetString("AZURE_EVENTHUBS_CONNECTION_STRING"), viper.GetString("AZURE_EVENTHUBS_EVENTHUB"))
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	producer := azureeventhubsservices.NewAzureEventHubsProducer(producerClient)
+
+	if secondaryNamespace, secondaryConnectionString := viper.GetString("AZURE_EVENTHUBS_SECONDARY_NAMESPACE"), viper.GetString("AZURE_EVENTHUBS_SECONDARY_CONNECTION_STRING"); secondaryNamespace != "" || secondaryConnectionString != "" {
+		secondaryEventHub := viper.GetString("AZURE_EVENTHUBS_SECONDARY_EVENTHUB")
+
+		if secondaryEventHub == "" {
+			secondaryEventHub = viper.GetString("AZURE_EVENTHUBS_EVENTHUB")
+		}
+
+		secondaryProducerClient, err := newProducerClient(secondaryNamespace, secondaryConnectionString, secondaryEventHub)
+
+		if err != nil {
+			log.Panic(err)
+		}
+
+		failoverProducerOptions := &azureeventhubsservices.FailoverAzureEventHubsProducerOptions{
+			FailureThreshold:  viper.GetInt("AZURE_EVENTHUBS_FAILOVER_THRESHOLD"),
+			ProbeInterval:     viper.GetDuration("AZURE_EVENTHUBS_FAILOVER_PROBE_INTERVAL"),
+			FailbackThreshold: viper.GetInt("AZURE_EVENTHUBS_FAILBACK_THRESHOLD"),
+			RetryDelay:        viper.GetDuration("AZURE_EVENTHUBS_FAILOVER_RETRY_DELAY"),
+			MaxRetryDelay:     viper.GetDuration("AZURE_EVENTHUBS_FAILOVER_MAX_RETRY_DELAY"),
+			Logger:            logger,
+		}
+
+		failoverProducer, err := azureeventhubsservices.NewFailoverAzureEventHubsProducer(producer, azureeventhubsservices.NewAzureEventHubsProducer(secondaryProducerClient), failoverProducerOptions)
+
+		if err != nil {
+			log.Panic(err)
+		}
+
+		go func() {
+			if err := failoverProducer.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				logger.Error("Failover producer stopped", "error", err)
+			}
+		}()
+
+		producer = failoverProducer
+	}
+
+	defer producer.Close(ctx)
+
+	serializerOptions := &serializers.SerializerOptions{
+		Kind:            viper.GetString("SERIALIZER_KIND"),
+		Subject:         viper.GetString("SERIALIZER_SUBJECT"),
+		RegisterTimeout: viper.GetDuration("SCHEMA_REGISTRY_TIMEOUT"),
+		SchemaRegistry: &serializers.SchemaRegistryOptions{
+			URL:      viper.GetString("SCHEMA_REGISTRY_URL"),
+			Username: viper.GetString("SCHEMA_REGISTRY_USERNAME"),
+			Password: viper.GetString("SCHEMA_REGISTRY_PASSWORD"),
+			File:     viper.GetString("SCHEMA_REGISTRY_FILE"),
+		},
+	}
+
+	if serializerOptions.Subject == "" {
+		serializerOptions.Subject = viper.GetString("AZURE_EVENTHUBS_EVENTHUB") + "-value"
+	}
+
+	serializer, err := serializers.NewSerializerWithSchemaRegistry(ctx, serializerOptions)
 
 	if err != nil {
 		log.Panic(err)
 	}
 
-	defer producerClient.Close(ctx)
+	var cloudEventOptions *cloudevents.CloudEventOptions
+
+	if cloudEventMode := viper.GetString("CLOUDEVENTS_MODE"); cloudEventMode != "" {
+		if !cloudevents.IsValidMode(cloudEventMode) {
+			log.Panic(cloudevents.ErrInvalidMode)
+		}
+
+		cloudEventOptions = &cloudevents.CloudEventOptions{
+			Mode:   cloudEventMode,
+			Source: cloudevents.NewSource(viper.GetString("COLLECTOR_NAME")),
+		}
+	}
+
+	var partitionProducersScalingOptions *azureeventhubsservices.PartitionProducersScalingOptions
+
+	if viper.GetBool("PARTITION_PRODUCERS_SCALING") {
+		partitionProducersScalingOptions = &azureeventhubsservices.PartitionProducersScalingOptions{
+			MinCount:          viper.GetInt("PARTITION_PRODUCERS_MIN_COUNT"),
+			MaxCount:          viper.GetInt("PARTITION_PRODUCERS_MAX_COUNT"),
+			Interval:          viper.GetDuration("PARTITION_PRODUCERS_SCALING_INTERVAL"),
+			ScaleUpQueueRatio: viper.GetFloat64("PARTITION_PRODUCERS_SCALE_UP_QUEUE_RATIO"),
+			MaxSendLatency:    viper.GetDuration("PARTITION_PRODUCERS_MAX_SEND_LATENCY"),
+			Logger:            logger,
+		}
+
+		if viper.IsSet("PARTITION_PRODUCERS_SCALE_DOWN_QUEUE_RATIO") {
+			scaleDownQueueRatio := viper.GetFloat64("PARTITION_PRODUCERS_SCALE_DOWN_QUEUE_RATIO")
+
+			partitionProducersScalingOptions.ScaleDownQueueRatio = &scaleDownQueueRatio
+		}
+	}
 
 	collectorServiceOptions := &azureeventhubsservices.AzureEventHubsCollectorServiceOptions{
-		PartitionQueueLimit:     viper.GetInt("PARTITION_QUEUE_LIMIT"),
-		PartitionProducersCount: viper.GetInt("PARTITION_PRODUCERS_COUNT"),
+		PartitionQueueLimit:        viper.GetInt("PARTITION_QUEUE_LIMIT"),
+		PartitionProducersCount:    viper.GetInt("PARTITION_PRODUCERS_COUNT"),
+		PartitionBatchInterval:     viper.GetDuration("PARTITION_BATCH_INTERVAL"),
+		Serializer:                 serializer,
+		CloudEvent:                 cloudEventOptions,
+		SynchronousAcknowledgement: viper.GetBool("SYNCHRONOUS_ACKNOWLEDGEMENT"),
+		AcknowledgementTimeout:     viper.GetDuration("ACKNOWLEDGEMENT_TIMEOUT"),
+		PartitionProducersScaling:  partitionProducersScalingOptions,
 	}
 
-	collectorService, err := azureeventhubsservices.NewAzureEventHubsCollectorService(producerClient, collectorServiceOptions)
+	collectorService, err := azureeventhubsservices.NewAzureEventHubsCollectorService(producer, collectorServiceOptions)
 
 	if err != nil {
 		log.Panic(err)
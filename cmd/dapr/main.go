@@ -1,20 +1,34 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"log/slog"
 	"net/http"
+	"strings"
 
 	daprclient "github.com/dapr/go-sdk/client"
+	"github.com/dapr/go-sdk/service/common"
+	daprhttp "github.com/dapr/go-sdk/service/http"
+	"github.com/go-chi/chi/v5"
 	"github.com/spf13/viper"
+	"github.com/zdrgeo/bulk-data-collector/pkg/cloudevents"
 	"github.com/zdrgeo/bulk-data-collector/pkg/handlers"
+	"github.com/zdrgeo/bulk-data-collector/pkg/serializers"
 	daprservices "github.com/zdrgeo/bulk-data-collector/pkg/services/dapr"
 )
 
 const (
-	storeName  = "iotoperations-statestore"
-	pubSubName = "iotoperations-pubsub"
-	topicName  = "collector"
+	defaultStoreName  = "iotoperations-statestore"
+	defaultPubSubName = "iotoperations-pubsub"
+	defaultTopicName  = "collector"
+
+	defaultInvocationMethod = "collect"
+)
+
+var (
+	errSubscriptionTopicPublished = errors.New("subscription topic is a topic the collector publishes to")
 )
 
 var (
@@ -57,18 +71,173 @@ func main() {
 }
 
 func mainDapr() {
+	pubSubName := viper.GetString("DAPR_PUBSUB_NAME")
+
+	if pubSubName == "" {
+		pubSubName = defaultPubSubName
+	}
+
+	topicName := viper.GetString("DAPR_TOPIC_NAME")
+
+	if topicName == "" {
+		topicName = defaultTopicName
+	}
+
+	var storeName string
+
+	if viper.GetBool("DAPR_DEVICE_STATE") {
+		if storeName = viper.GetString("DAPR_STATESTORE_NAME"); storeName == "" {
+			storeName = defaultStoreName
+		}
+	}
+
+	serializerOptions := &serializers.SerializerOptions{
+		Kind:            viper.GetString("SERIALIZER_KIND"),
+		Subject:         viper.GetString("SERIALIZER_SUBJECT"),
+		RegisterTimeout: viper.GetDuration("SCHEMA_REGISTRY_TIMEOUT"),
+		SchemaRegistry: &serializers.SchemaRegistryOptions{
+			URL:      viper.GetString("SCHEMA_REGISTRY_URL"),
+			Username: viper.GetString("SCHEMA_REGISTRY_USERNAME"),
+			Password: viper.GetString("SCHEMA_REGISTRY_PASSWORD"),
+			File:     viper.GetString("SCHEMA_REGISTRY_FILE"),
+		},
+	}
+
+	if serializerOptions.Subject == "" {
+		serializerOptions.Subject = topicName + "-value"
+	}
+
+	serializer, err := serializers.NewSerializerWithSchemaRegistry(context.Background(), serializerOptions)
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var cloudEventOptions *cloudevents.CloudEventOptions
+
+	if cloudEventMode := viper.GetString("CLOUDEVENTS_MODE"); cloudEventMode != "" {
+		if !cloudevents.IsValidMode(cloudEventMode) {
+			log.Panic(cloudevents.ErrInvalidMode)
+		}
+
+		cloudEventOptions = &cloudevents.CloudEventOptions{
+			Mode:   cloudEventMode,
+			Source: cloudevents.NewSource(viper.GetString("COLLECTOR_NAME")),
+		}
+	}
+
 	collectorServiceOptions := &daprservices.DaprCollectorServiceOptions{
-		PubSubName: pubSubName,
-		TopicName:  topicName,
+		PubSubName:       pubSubName,
+		TopicName:        topicName,
+		Serializer:       serializer,
+		CloudEvent:       cloudEventOptions,
+		ContentType:      viper.GetString("DAPR_CONTENT_TYPE"),
+		RawPayload:       viper.GetBool("DAPR_RAW_PAYLOAD"),
+		TTL:              viper.GetDuration("DAPR_TTL"),
+		PartitionKey:     viper.GetBool("DAPR_PARTITION_KEY"),
+		Metadata:         viper.GetStringMapString("DAPR_METADATA"),
+		StateStoreName:   storeName,
+		Mode:             viper.GetString("DAPR_MODE"),
+		BulkSize:         viper.GetInt("DAPR_BULK_SIZE"),
+		BulkInterval:     viper.GetDuration("DAPR_BULK_INTERVAL"),
+		BindingName:      viper.GetString("DAPR_BINDING_NAME"),
+		BindingOperation: viper.GetString("DAPR_BINDING_OPERATION"),
+		ActorType:        viper.GetString("DAPR_ACTOR_TYPE"),
+	}
+
+	collectorService, err := daprservices.NewDaprCollectorService(daprClient, collectorServiceOptions)
+
+	if err != nil {
+		log.Panic(err)
 	}
 
-	collectorService := daprservices.NewDaprCollectorService(daprClient, collectorServiceOptions)
+	go func() {
+		if err := collectorService.Run(context.Background()); err != nil && !errors.Is(err, context.Canceled) {
+			logger.Error("Collector service stopped", "error", err)
+		}
+	}()
 
 	collectorHandler := handlers.NewCollectorHandler(collectorService)
 
-	http.Handle("/collector", http.HandlerFunc(collectorHandler.Collect))
+	mux := chi.NewRouter()
+
+	mux.Handle("/collector", http.HandlerFunc(collectorHandler.Collect))
+
+	// The Dapr service serves the collector handler alongside the endpoints that Dapr calls, for example to host the actors
+	service := daprhttp.NewServiceWithMux(":8088", mux)
+
+	if collectorServiceOptions.Mode == daprservices.Mode_Actor {
+		deviceActorOptions := &daprservices.DaprDeviceActorOptions{
+			ActorType:             collectorServiceOptions.ActorType,
+			ReportingInterval:     viper.GetDuration("DAPR_ACTOR_REPORTING_INTERVAL"),
+			MissedReportTolerance: viper.GetFloat64("DAPR_ACTOR_MISSED_REPORT_TOLERANCE"),
+			SmoothingFactor:       viper.GetFloat64("DAPR_ACTOR_SMOOTHING_FACTOR"),
+			MaxMissedReports:      viper.GetInt("DAPR_ACTOR_MAX_MISSED_REPORTS"),
+			Logger:                logger,
+		}
+
+		service.RegisterActorImplFactoryContext(daprservices.NewDaprDeviceActorFactory(daprClient, deviceActorOptions))
+	}
+
+	daprCollectorHandler := handlers.NewDaprCollectorHandler(collectorService)
+
+	if subscriptionTopicName := viper.GetString("DAPR_SUBSCRIPTION_TOPIC_NAME"); subscriptionTopicName != "" {
+		subscriptionPubSubName := viper.GetString("DAPR_SUBSCRIPTION_PUBSUB_NAME")
+
+		if subscriptionPubSubName == "" {
+			subscriptionPubSubName = pubSubName
+		}
+
+		// The collector would consume its own events
+		if subscriptionPubSubName == pubSubName && publishes(collectorServiceOptions.Mode) && isPublishTopic(subscriptionTopicName, topicName) {
+			log.Panic(errSubscriptionTopicPublished)
+		}
+
+		subscription := &common.Subscription{
+			PubsubName: subscriptionPubSubName,
+			Topic:      subscriptionTopicName,
+			Route:      "/subscriptions/" + subscriptionTopicName,
+		}
+
+		if err := service.AddTopicEventHandler(subscription, daprCollectorHandler.CollectTopicEvent); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	invocationMethod := viper.GetString("DAPR_INVOCATION_METHOD")
 
-	if err := http.ListenAndServe(":8088", nil); err != nil && err != http.ErrServerClosed {
+	if invocationMethod == "" {
+		invocationMethod = defaultInvocationMethod
+	}
+
+	if err := service.AddServiceInvocationHandler(invocationMethod, daprCollectorHandler.CollectInvocation); err != nil {
 		log.Panic(err)
 	}
+
+	if err := service.Start(); err != nil && err != http.ErrServerClosed {
+		log.Panic(err)
+	}
+}
+
+// publishes tells if the collector publishes the reports to the pub/sub in the mode.
+func publishes(mode string) bool {
+	switch mode {
+	case "", daprservices.Mode_PubSub, daprservices.Mode_BulkPubSub:
+		return true
+	default:
+		return false
+	}
+}
+
+// isPublishTopic tells if the subscription topic is the root topic or covers the topics of the devices under it, including with a multi-level wildcard.
+func isPublishTopic(subscriptionTopicName, topicName string) bool {
+	if subscriptionTopicName == topicName || strings.HasPrefix(subscriptionTopicName, topicName+"/") {
+		return true
+	}
+
+	if prefix, ok := strings.CutSuffix(subscriptionTopicName, "#"); ok {
+		return strings.HasPrefix(topicName+"/", prefix)
+	}
+
+	return false
 }
@@ -3,26 +3,59 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"log"
 	"log/slog"
+	"math"
 	"net/http"
 	"net/url"
+	"os"
+	"time"
 
 	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/autopaho/queue"
 	"github.com/eclipse/paho.golang/autopaho/queue/memory"
 	"github.com/eclipse/paho.golang/paho"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/viper"
+	"github.com/zdrgeo/bulk-data-collector/pkg/cloudevents"
 	handlers "github.com/zdrgeo/bulk-data-collector/pkg/handlers"
+	"github.com/zdrgeo/bulk-data-collector/pkg/serializers"
 	mqttservices "github.com/zdrgeo/bulk-data-collector/pkg/services/mqtt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/sdk/metric"
+)
+
+const (
+	// MQTT queue kinds
+	queueKindMemory = "Memory"
+	queueKindFile   = "File"
+	// MQTT queue kinds
+)
+
+var (
+	errInvalidQueueKind = errors.New("invalid queue kind")
+	errInvalidCAFile    = errors.New("invalid CA file")
+	errInvalidDuration  = errors.New("invalid duration")
 )
 
 var (
 	logger            *slog.Logger
 	connectionManager *autopaho.ConnectionManager
+	sparkplugNode     *mqttservices.SparkplugNode
+	connectionStatus  *mqttservices.ConnectionStatus
+	logLevel          *slog.LevelVar
+	collectorControl  *mqttservices.CollectorControl
 )
 
 func init() {
-	logger = slog.Default()
+	logLevel = &slog.LevelVar{}
+	logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+
+	slog.SetDefault(logger)
 	// Use otelslog bridge to integrate with OpenTelemetry (https://pkg.go.dev/go.opentelemetry.io/otel/sdk/log)
 	// logger := slog.New(slog.NewTextHandler(nil, &slog.HandlerOptions{AddSource: true}))
 	// logger := slog.New(slog.NewJSONHandler(nil, &slog.HandlerOptions{AddSource: true}))
@@ -44,39 +77,266 @@ func init() {
 func initMQTT() {
 	var err error
 
-	serverUrl, err := url.Parse(viper.GetString("MQTT_SERVER_URL"))
+	prometheusExporter, err := prometheus.New()
 
 	if err != nil {
 		log.Panic(err)
 	}
 
-	certificate, err := tls.LoadX509KeyPair(viper.GetString("MQTT_CERT_FILE"), viper.GetString("MQTT_KEY_FILE"))
+	meterProvider := metric.NewMeterProvider(
+		metric.WithReader(prometheusExporter),
+	)
+
+	otel.SetMeterProvider(meterProvider)
+
+	serverUrls, err := newServerUrls()
 
 	if err != nil {
 		log.Panic(err)
 	}
 
-	tlsCfg := &tls.Config{
-		Certificates: []tls.Certificate{certificate},
+	tlsCfg, err := newTLSConfig()
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if connectionStatus, err = mqttservices.NewConnectionStatus(); err != nil {
+		log.Panic(err)
+	}
+
+	keepAlive, err := durationSeconds("MQTT_KEEP_ALIVE", 20*time.Second, math.MaxUint16)
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	sessionExpiryInterval, err := durationSeconds("MQTT_SESSION_EXPIRY_INTERVAL", 1*time.Hour, math.MaxUint32)
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	connectTimeout, err := duration("MQTT_CONNECT_TIMEOUT", 0)
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	clientQueue, err := newQueue(viper.GetString("MQTT_QUEUE"))
+
+	if err != nil {
+		log.Panic(err)
 	}
 
 	clientConfig := autopaho.ClientConfig{
-		Queue:                         memory.New(),
-		ServerUrls:                    []*url.URL{serverUrl},
-		KeepAlive:                     20,
-		CleanStartOnInitialConnection: false,
-		SessionExpiryInterval:         3600,
+		Queue:                         clientQueue,
+		ServerUrls:                    serverUrls,
+		KeepAlive:                     uint16(keepAlive),
+		CleanStartOnInitialConnection: viper.GetBool("MQTT_CLEAN_START"),
+		SessionExpiryInterval:         uint32(sessionExpiryInterval),
+		ConnectTimeout:                connectTimeout,
 		ConnectUsername:               viper.GetString("MQTT_CONNECT_USERNAME"),
 		ConnectPassword:               []byte(viper.GetString("MQTT_CONNECT_PASSWORD")),
 		TlsCfg:                        tlsCfg,
+		OnConnectionUp:                connectionStatus.OnConnectionUp,
+		OnConnectError:                connectionStatus.OnConnectError,
 		ClientConfig: paho.ClientConfig{
-			ClientID: viper.GetString("MQTT_CLIENT_ID"),
+			ClientID:           viper.GetString("MQTT_CLIENT_ID"),
+			OnClientError:      connectionStatus.OnClientError,
+			OnServerDisconnect: connectionStatus.OnServerDisconnect,
 		},
 	}
 
+	if viper.GetBool("MQTT_SPARKPLUG") {
+		edgeNodeID := viper.GetString("MQTT_SPARKPLUG_EDGE_NODE_ID")
+
+		if edgeNodeID == "" {
+			edgeNodeID = viper.GetString("COLLECTOR_NAME")
+		}
+
+		sparkplugNodeOptions := &mqttservices.SparkplugNodeOptions{
+			GroupID:       viper.GetString("MQTT_SPARKPLUG_GROUP_ID"),
+			EdgeNodeID:    edgeNodeID,
+			DeviceTimeout: viper.GetDuration("MQTT_SPARKPLUG_DEVICE_TIMEOUT"),
+			Logger:        logger,
+		}
+
+		if sparkplugNode, err = mqttservices.NewSparkplugNode(sparkplugNodeOptions); err != nil {
+			log.Panic(err)
+		}
+
+		clientConfig.ConnectPacketBuilder = sparkplugNode.ConnectPacketBuilder
+		clientConfig.OnConnectionUp = func(connectionManager *autopaho.ConnectionManager, connack *paho.Connack) {
+			connectionStatus.OnConnectionUp(connectionManager, connack)
+			sparkplugNode.OnConnectionUp(connectionManager, connack)
+		}
+	}
+
+	collectorControl = mqttservices.NewCollectorControl(logLevel)
+
+	var commandHandler *mqttservices.CommandHandler
+
+	if commandSecret := viper.GetString("MQTT_COMMAND_SECRET"); commandSecret != "" {
+		commandHandlerOptions := &mqttservices.CommandHandlerOptions{
+			CollectorName: viper.GetString("COLLECTOR_NAME"),
+			Secret:        []byte(commandSecret),
+			MaxAge:        viper.GetDuration("MQTT_COMMAND_MAX_AGE"),
+			Logger:        logger,
+		}
+
+		if commandHandler, err = mqttservices.NewCommandHandler(collectorControl, commandHandlerOptions); err != nil {
+			log.Panic(err)
+		}
+
+		onConnectionUp := clientConfig.OnConnectionUp
+
+		clientConfig.OnConnectionUp = func(connectionManager *autopaho.ConnectionManager, connack *paho.Connack) {
+			onConnectionUp(connectionManager, connack)
+			commandHandler.OnConnectionUp(connectionManager, connack)
+		}
+	}
+
 	if connectionManager, err = autopaho.NewConnection(context.Background(), clientConfig); err != nil {
 		log.Panic(err)
 	}
+
+	if sparkplugNode != nil {
+		connectionManager.AddOnPublishReceived(sparkplugNode.OnPublishReceived)
+	}
+
+	if commandHandler != nil {
+		connectionManager.AddOnPublishReceived(commandHandler.OnPublishReceived)
+	}
+}
+
+// newServerUrls parses the space separated MQTT_SERVER_URLS, which the client tries in turn, or MQTT_SERVER_URL. The schemes mqtt, tls, ws and wss select the transport.
+// duration reads the duration of the key, or the default duration if the key is not set. Unlike viper.GetDuration, which reads a number without unit as nanoseconds, it requires a unit, for example 20s, and a non-negative duration.
+func duration(key string, defaultDuration time.Duration) (time.Duration, error) {
+	if !viper.IsSet(key) {
+		return defaultDuration, nil
+	}
+
+	value, err := time.ParseDuration(viper.GetString(key))
+
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s: %w", errInvalidDuration, key, err)
+	}
+
+	if value < 0 {
+		return 0, fmt.Errorf("%w: %s is negative", errInvalidDuration, key)
+	}
+
+	return value, nil
+}
+
+// durationSeconds reads the duration of the key like duration, in seconds rounded up, which must not exceed the maximum of the MQTT field.
+func durationSeconds(key string, defaultDuration time.Duration, maxSeconds uint64) (uint64, error) {
+	value, err := duration(key, defaultDuration)
+
+	if err != nil {
+		return 0, err
+	}
+
+	seconds := math.Ceil(value.Seconds())
+
+	if seconds > float64(maxSeconds) {
+		return 0, fmt.Errorf("%w: %s exceeds %ds", errInvalidDuration, key, maxSeconds)
+	}
+
+	return uint64(seconds), nil
+}
+
+func newServerUrls() ([]*url.URL, error) {
+	rawServerUrls := viper.GetStringSlice("MQTT_SERVER_URLS")
+
+	if len(rawServerUrls) == 0 {
+		rawServerUrls = []string{viper.GetString("MQTT_SERVER_URL")}
+	}
+
+	serverUrls := make([]*url.URL, 0, len(rawServerUrls))
+
+	for _, rawServerUrl := range rawServerUrls {
+		serverUrl, err := url.Parse(rawServerUrl)
+
+		if err != nil {
+			return nil, err
+		}
+
+		serverUrls = append(serverUrls, serverUrl)
+	}
+
+	return serverUrls, nil
+}
+
+// newTLSConfig creates the TLS configuration with the optional client certificate and CA bundle. Without a CA bundle, the system CAs are used.
+func newTLSConfig() (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		ServerName: viper.GetString("MQTT_TLS_SERVER_NAME"),
+	}
+
+	if certFile, keyFile := viper.GetString("MQTT_CERT_FILE"), viper.GetString("MQTT_KEY_FILE"); certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tlsCfg.Certificates = []tls.Certificate{certificate}
+	}
+
+	if caFile := viper.GetString("MQTT_CA_FILE"); caFile != "" {
+		caData, err := os.ReadFile(caFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		certPool := x509.NewCertPool()
+
+		if !certPool.AppendCertsFromPEM(caData) {
+			return nil, errInvalidCAFile
+		}
+
+		tlsCfg.RootCAs = certPool
+	}
+
+	return tlsCfg, nil
+}
+
+func newQueue(queueKind string) (queue.Queue, error) {
+	switch queueKind {
+	case "", queueKindMemory:
+		return memory.New(), nil
+	case queueKindFile:
+		queuePath := viper.GetString("MQTT_QUEUE_PATH")
+
+		if queuePath == "" {
+			queuePath = "queue"
+		}
+
+		fileQueueOptions := &mqttservices.FileQueueOptions{
+			Path:           queuePath,
+			MaxCount:       viper.GetInt("MQTT_QUEUE_MAX_COUNT"),
+			MaxBytes:       viper.GetInt64("MQTT_QUEUE_MAX_BYTES"),
+			EvictionPolicy: viper.GetString("MQTT_QUEUE_EVICTION_POLICY"),
+		}
+
+		return mqttservices.NewFileQueue(fileQueueOptions)
+	}
+
+	return nil, errInvalidQueueKind
+}
+
+// ready reports the collector as ready while the connection to the broker is up.
+func ready(writer http.ResponseWriter, request *http.Request) {
+	if !connectionStatus.IsUp() {
+		http.Error(writer, "Service Unavailable", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	writer.WriteHeader(http.StatusOK)
 }
 
 func main() {
@@ -84,15 +344,93 @@ func main() {
 }
 
 func mainMQTT() {
+	serializerOptions := &serializers.SerializerOptions{
+		Kind:            viper.GetString("SERIALIZER_KIND"),
+		Subject:         viper.GetString("SERIALIZER_SUBJECT"),
+		RegisterTimeout: viper.GetDuration("SCHEMA_REGISTRY_TIMEOUT"),
+		SchemaRegistry: &serializers.SchemaRegistryOptions{
+			URL:      viper.GetString("SCHEMA_REGISTRY_URL"),
+			Username: viper.GetString("SCHEMA_REGISTRY_USERNAME"),
+			Password: viper.GetString("SCHEMA_REGISTRY_PASSWORD"),
+			File:     viper.GetString("SCHEMA_REGISTRY_FILE"),
+		},
+	}
+
+	if serializerOptions.Subject == "" {
+		serializerOptions.Subject = "collector-" + viper.GetString("COLLECTOR_NAME") + "-value"
+	}
+
+	serializer, err := serializers.NewSerializerWithSchemaRegistry(context.Background(), serializerOptions)
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	var cloudEventOptions *cloudevents.CloudEventOptions
+
+	if cloudEventMode := viper.GetString("CLOUDEVENTS_MODE"); cloudEventMode != "" {
+		if !cloudevents.IsValidMode(cloudEventMode) {
+			log.Panic(cloudevents.ErrInvalidMode)
+		}
+
+		cloudEventOptions = &cloudevents.CloudEventOptions{
+			Mode:   cloudEventMode,
+			Source: cloudevents.NewSource(viper.GetString("COLLECTOR_NAME")),
+		}
+	}
+
 	mqttCollectorServiceOptions := &mqttservices.MQTTCollectorServiceOptions{
-		CollectorName: viper.GetString("COLLECTOR_NAME"),
+		CollectorName:  viper.GetString("COLLECTOR_NAME"),
+		Serializer:     serializer,
+		CloudEvent:     cloudEventOptions,
+		TopicTemplate:  viper.GetString("MQTT_TOPIC_TEMPLATE"),
+		Retain:         viper.GetBool("MQTT_RETAIN"),
+		MessageExpiry:  viper.GetDuration("MQTT_MESSAGE_EXPIRY"),
+		ContentType:    viper.GetString("MQTT_CONTENT_TYPE"),
+		UserProperties: viper.GetStringMapString("MQTT_USER_PROPERTIES"),
+		SparkplugNode:  sparkplugNode,
+		Control:        collectorControl,
+	}
+
+	if viper.GetBool("MQTT_PARAMETER_TOPICS") {
+		mqttCollectorServiceOptions.ParameterTopics = &mqttservices.MQTTParameterTopicsOptions{
+			TopicTemplate:     viper.GetString("MQTT_PARAMETER_TOPIC_TEMPLATE"),
+			ParameterPrefixes: viper.GetStringSlice("MQTT_PARAMETER_PREFIXES"),
+			StateTTL:          viper.GetDuration("MQTT_PARAMETER_STATE_TTL"),
+		}
+	}
+
+	if viper.IsSet("MQTT_QOS") {
+		qosValue := viper.GetUint("MQTT_QOS")
+
+		if qosValue > 2 {
+			log.Panic(mqttservices.ErrInvalidQoS)
+		}
+
+		qos := byte(qosValue)
+
+		mqttCollectorServiceOptions.QoS = &qos
 	}
 
-	collectorService := mqttservices.NewMQTTCollectorService(connectionManager, mqttCollectorServiceOptions)
+	collectorService, err := mqttservices.NewMQTTCollectorService(connectionManager, mqttCollectorServiceOptions)
+
+	if err != nil {
+		log.Panic(err)
+	}
 
 	collectorHandler := handlers.NewCollectorHandler(collectorService)
 
+	http.Handle("/metrics", promhttp.Handler())
 	http.Handle("/collector", http.HandlerFunc(collectorHandler.Collect))
+	http.Handle("/ready", http.HandlerFunc(ready))
+
+	if sparkplugNode != nil {
+		go func() {
+			if err := sparkplugNode.Run(context.Background()); err != nil {
+				logger.Error("Sparkplug node stopped", "error", err)
+			}
+		}()
+	}
 
 	if err := http.ListenAndServe(":8088", nil); err != nil && err != http.ErrServerClosed {
 		log.Panic(err)
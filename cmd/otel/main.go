@@ -2,11 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -19,12 +25,51 @@ import (
 	"go.opentelemetry.io/otel/exporters/prometheus"
 	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
 	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 	"go.opentelemetry.io/otel/sdk/resource"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	// Exporters
+	exporterOTLP       = "otlp"
+	exporterPrometheus = "prometheus"
+	exporterStdout     = "stdout"
+	// Exporters
+
+	// OTLP protocols
+	otlpProtocolGRPC = "grpc"
+	otlpProtocolHTTP = "http"
+	// OTLP protocols
+
+	// Temporality preferences
+	temporalityCumulative = "cumulative"
+	temporalityDelta      = "delta"
+	temporalityLowMemory  = "lowmemory"
+	// Temporality preferences
+
+	compressionGzip = "gzip"
+
+	// Time to complete the pending requests on shutdown
+	shutdownTimeout = 30 * time.Second
 )
 
 var (
-	logger *slog.Logger
+	errInvalidExporter      = errors.New("invalid exporter")
+	errInvalidOTLPProtocol  = errors.New("invalid OTLP protocol")
+	errInvalidTemporality   = errors.New("invalid temporality")
+	errInvalidCompression   = errors.New("invalid compression")
+	errInvalidCAFile        = errors.New("invalid CA file")
+	errOTLPExporterRequired = errors.New("OTLP exporter required")
+)
+
+var (
+	logger                  *slog.Logger
+	collectorServiceOptions *otelservices.OTelCollectorServiceOptions
+	otelResource            *resource.Resource
+	meterProvider           *metric.MeterProvider
+	prometheusExporter      *prometheus.Exporter
 )
 
 func init() {
@@ -44,79 +89,243 @@ func init() {
 		log.Panic(err)
 	}
 
+	otelConfig := viper.Sub("otel")
+
+	collectorServiceOptions = &otelservices.OTelCollectorServiceOptions{}
+
+	if err := otelConfig.Unmarshal(collectorServiceOptions); err != nil {
+		log.Panic(err)
+	}
+
 	initOTel()
 }
 
 func initOTel() {
-	encoder := json.NewEncoder(os.Stdout)
-
-	encoder.SetIndent("", "  ")
-
-	stdoutExporter, err := stdoutmetric.New(
-		stdoutmetric.WithEncoder(encoder),
-		stdoutmetric.WithoutTimestamps(),
-	)
+	temporalitySelector, err := newTemporalitySelector(viper.GetString("OTEL_TEMPORALITY"))
 
 	if err != nil {
 		log.Panic(err)
 	}
 
-	_ = stdoutExporter
+	exportInterval := 10 * time.Second
+
+	if viper.IsSet("OTEL_EXPORT_INTERVAL") {
+		exportInterval = viper.GetDuration("OTEL_EXPORT_INTERVAL")
+	}
+
+	exporters := newExporters()
 
-	otlpgrpcExporter, err := otlpmetricgrpc.New(
-		context.Background(),
-		otlpmetricgrpc.WithEndpoint("localhost:4317"),
-		otlpmetricgrpc.WithInsecure(),
+	otelResource = resource.NewSchemaless(
+		semconv.ServiceName("bulk-data-collector"),
 	)
 
-	if err != nil {
-		log.Panic(err)
+	meterProviderOptions := []metric.Option{
+		metric.WithResource(otelResource),
+		metric.WithView(otelservices.NewOTelViews(collectorServiceOptions)...),
 	}
 
-	_ = otlpgrpcExporter
+	for _, exporter := range exporters {
+		switch exporter {
+		case exporterOTLP:
+			otlpExporter, err := newOTLPExporter(context.Background(), temporalitySelector)
 
-	otlphttpExporter, err := otlpmetrichttp.New(
-		context.Background(),
-		otlpmetrichttp.WithEndpoint("localhost:4318"),
-		otlpmetrichttp.WithInsecure(),
-	)
+			if err != nil {
+				log.Panic(err)
+			}
 
-	if err != nil {
-		log.Panic(err)
+			meterProviderOptions = append(meterProviderOptions, metric.WithReader(metric.NewPeriodicReader(otlpExporter, metric.WithInterval(exportInterval))))
+		case exporterPrometheus:
+			if prometheusExporter, err = prometheus.New(); err != nil {
+				log.Panic(err)
+			}
+
+			meterProviderOptions = append(meterProviderOptions, metric.WithReader(prometheusExporter))
+		case exporterStdout:
+			encoder := json.NewEncoder(os.Stdout)
+
+			encoder.SetIndent("", "  ")
+
+			stdoutExporter, err := stdoutmetric.New(
+				stdoutmetric.WithEncoder(encoder),
+				stdoutmetric.WithTemporalitySelector(temporalitySelector),
+			)
+
+			if err != nil {
+				log.Panic(err)
+			}
+
+			meterProviderOptions = append(meterProviderOptions, metric.WithReader(metric.NewPeriodicReader(stdoutExporter, metric.WithInterval(exportInterval))))
+		default:
+			log.Panic(errInvalidExporter)
+		}
 	}
 
-	_ = otlphttpExporter
+	meterProvider = metric.NewMeterProvider(meterProviderOptions...)
 
-	resource := resource.NewSchemaless(
-		semconv.ServiceName("bulk-data-collector"),
-	)
+	otel.SetMeterProvider(meterProvider)
+}
 
-	_ = resource
+// newExporters returns the space separated OTEL_EXPORTERS, which default to otlp.
+func newExporters() []string {
+	exporters := viper.GetStringSlice("OTEL_EXPORTERS")
 
-	periodicReader := metric.NewPeriodicReader(
-		// stdoutExporter,
-		otlpgrpcExporter,
-		// otlphttpExporter,
-		metric.WithInterval(10*time.Second),
-	)
+	if len(exporters) == 0 {
+		exporters = []string{exporterOTLP}
+	}
 
-	_ = periodicReader
+	return exporters
+}
 
-	prometheusExporter, err := prometheus.New()
+// newOTLPExporter creates the OTLP exporter of the OTEL_OTLP_PROTOCOL protocol. Without OTEL_OTLP_TLS, the connection is not secured.
+func newOTLPExporter(ctx context.Context, temporalitySelector metric.TemporalitySelector) (metric.Exporter, error) {
+	var tlsCfg *tls.Config
 
-	if err != nil {
-		log.Panic(err)
+	if viper.GetBool("OTEL_OTLP_TLS") {
+		var err error
+
+		if tlsCfg, err = newTLSConfig(); err != nil {
+			return nil, err
+		}
 	}
 
-	_ = prometheusExporter
+	endpoint := viper.GetString("OTEL_OTLP_ENDPOINT")
+	headers := viper.GetStringMapString("OTEL_OTLP_HEADERS")
+	compression := viper.GetString("OTEL_OTLP_COMPRESSION")
+	timeout := viper.GetDuration("OTEL_OTLP_TIMEOUT")
 
-	meterProvider := metric.NewMeterProvider(
-		metric.WithResource(resource),
-		metric.WithReader(periodicReader),
-		// metric.WithReader(prometheusExporter),
-	)
+	if compression != "" && compression != compressionGzip {
+		return nil, errInvalidCompression
+	}
 
-	otel.SetMeterProvider(meterProvider)
+	switch viper.GetString("OTEL_OTLP_PROTOCOL") {
+	case "", otlpProtocolGRPC:
+		if endpoint == "" {
+			endpoint = "localhost:4317"
+		}
+
+		options := []otlpmetricgrpc.Option{
+			otlpmetricgrpc.WithEndpoint(endpoint),
+			otlpmetricgrpc.WithTemporalitySelector(temporalitySelector),
+		}
+
+		if tlsCfg != nil {
+			options = append(options, otlpmetricgrpc.WithTLSCredentials(credentials.NewTLS(tlsCfg)))
+		} else {
+			options = append(options, otlpmetricgrpc.WithInsecure())
+		}
+
+		if len(headers) != 0 {
+			options = append(options, otlpmetricgrpc.WithHeaders(headers))
+		}
+
+		if compression == compressionGzip {
+			options = append(options, otlpmetricgrpc.WithCompressor(compressionGzip))
+		}
+
+		if timeout > 0 {
+			options = append(options, otlpmetricgrpc.WithTimeout(timeout))
+		}
+
+		return otlpmetricgrpc.New(ctx, options...)
+	case otlpProtocolHTTP:
+		if endpoint == "" {
+			endpoint = "localhost:4318"
+		}
+
+		options := []otlpmetrichttp.Option{
+			otlpmetrichttp.WithEndpoint(endpoint),
+			otlpmetrichttp.WithTemporalitySelector(temporalitySelector),
+		}
+
+		if tlsCfg != nil {
+			options = append(options, otlpmetrichttp.WithTLSClientConfig(tlsCfg))
+		} else {
+			options = append(options, otlpmetrichttp.WithInsecure())
+		}
+
+		if urlPath := viper.GetString("OTEL_OTLP_URL_PATH"); urlPath != "" {
+			options = append(options, otlpmetrichttp.WithURLPath(urlPath))
+		}
+
+		if len(headers) != 0 {
+			options = append(options, otlpmetrichttp.WithHeaders(headers))
+		}
+
+		if compression == compressionGzip {
+			options = append(options, otlpmetrichttp.WithCompression(otlpmetrichttp.GzipCompression))
+		}
+
+		if timeout > 0 {
+			options = append(options, otlpmetrichttp.WithTimeout(timeout))
+		}
+
+		return otlpmetrichttp.New(ctx, options...)
+	}
+
+	return nil, errInvalidOTLPProtocol
+}
+
+// newTemporalitySelector selects the temporality of the instrument kinds like the OTEL_EXPORTER_OTLP_METRICS_TEMPORALITY_PREFERENCE of the OTel specification.
+func newTemporalitySelector(temporality string) (metric.TemporalitySelector, error) {
+	switch temporality {
+	case "", temporalityCumulative:
+		return metric.DefaultTemporalitySelector, nil
+	case temporalityDelta:
+		return func(instrumentKind metric.InstrumentKind) metricdata.Temporality {
+			switch instrumentKind {
+			case metric.InstrumentKindUpDownCounter, metric.InstrumentKindObservableUpDownCounter:
+				return metricdata.CumulativeTemporality
+			default:
+				return metricdata.DeltaTemporality
+			}
+		}, nil
+	case temporalityLowMemory:
+		return func(instrumentKind metric.InstrumentKind) metricdata.Temporality {
+			switch instrumentKind {
+			case metric.InstrumentKindCounter, metric.InstrumentKindHistogram:
+				return metricdata.DeltaTemporality
+			default:
+				return metricdata.CumulativeTemporality
+			}
+		}, nil
+	}
+
+	return nil, errInvalidTemporality
+}
+
+// newTLSConfig creates the TLS configuration with the optional client certificate and CA bundle. Without a CA bundle, the system CAs are used.
+func newTLSConfig() (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		ServerName: viper.GetString("OTEL_OTLP_TLS_SERVER_NAME"),
+	}
+
+	if certFile, keyFile := viper.GetString("OTEL_OTLP_CERT_FILE"), viper.GetString("OTEL_OTLP_KEY_FILE"); certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tlsCfg.Certificates = []tls.Certificate{certificate}
+	}
+
+	if caFile := viper.GetString("OTEL_OTLP_CA_FILE"); caFile != "" {
+		caData, err := os.ReadFile(caFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		certPool := x509.NewCertPool()
+
+		if !certPool.AppendCertsFromPEM(caData) {
+			return nil, errInvalidCAFile
+		}
+
+		tlsCfg.RootCAs = certPool
+	}
+
+	return tlsCfg, nil
 }
 
 func main() {
@@ -124,12 +333,27 @@ func main() {
 }
 
 func mainOTel() {
-	otelConfig := viper.Sub("otel")
-
-	collectorServiceOptions := &otelservices.OTelCollectorServiceOptions{}
-
-	if err := otelConfig.Unmarshal(collectorServiceOptions); err != nil {
-		log.Panic(err)
+	var dataPointsExporter metric.Exporter
+
+	if viper.GetBool("OTEL_DEVICE_TIMESTAMPS") {
+		// The data points are exported via OTLP only
+		if !slices.Contains(newExporters(), exporterOTLP) {
+			log.Panic(errOTLPExporterRequired)
+		}
+
+		var err error
+
+		if dataPointsExporter, err = newOTLPExporter(context.Background(), metric.DefaultTemporalitySelector); err != nil {
+			log.Panic(err)
+		}
+
+		collectorServiceOptions.DataPoints = &otelservices.OTelDataPointsOptions{
+			Exporter:       dataPointsExporter,
+			Resource:       otelResource,
+			ExportInterval: viper.GetDuration("OTEL_EXPORT_INTERVAL"),
+			MaxDataPoints:  viper.GetInt("OTEL_DATA_POINTS_MAX_COUNT"),
+			Logger:         logger,
+		}
 	}
 
 	collectorService, err := otelservices.NewOTelCollectorService(collectorServiceOptions)
@@ -138,12 +362,71 @@ func mainOTel() {
 		log.Panic(err)
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	defer stop()
+
+	// The collector service runs until the server has completed the pending requests, so their data points are exported with the final export
+	runCtx, cancelRun := context.WithCancel(context.Background())
+
+	runDone := make(chan struct{})
+
+	go func() {
+		defer close(runDone)
+
+		if err := collectorService.Run(runCtx); err != nil {
+			logger.Error("Collector service run failed", "error", err)
+		}
+	}()
+
 	collectorHandler := handlers.NewCollectorHandler(collectorService)
 
-	http.Handle("/metrics", promhttp.Handler())
+	if prometheusExporter != nil {
+		http.Handle("/metrics", promhttp.Handler())
+	}
+
 	http.Handle("/collector", http.HandlerFunc(collectorHandler.Collect))
 
-	if err := http.ListenAndServe(":8088", nil); err != nil && err != http.ErrServerClosed {
+	server := &http.Server{Addr: ":8088"}
+
+	shutdownDone := make(chan struct{})
+
+	go func() {
+		defer close(shutdownDone)
+
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Server shutdown failed", "error", err)
+		}
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Panic(err)
 	}
+
+	<-shutdownDone
+
+	cancelRun()
+
+	<-runDone
+
+	// The final collection of the meter provider and the final export of the data points are sent before the exporters are closed
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+
+	defer cancel()
+
+	if dataPointsExporter != nil {
+		if err := dataPointsExporter.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Data points exporter shutdown failed", "error", err)
+		}
+	}
+
+	if err := meterProvider.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Meter provider shutdown failed", "error", err)
+	}
 }
@@ -0,0 +1,178 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/spec.md
+
+var (
+	ErrInvalidMode = errors.New("invalid mode")
+)
+
+const (
+	SpecVersion           = "1.0"
+	StructuredContentType = "application/cloudevents+json"
+	TypePrefix            = "com.github.zdrgeo.bulkdatacollector.report"
+	// DefaultSource is the source of the events when the options do not set it
+	DefaultSource = "/collector"
+)
+
+const (
+	ModeStructured = "Structured"
+	ModeBinary     = "Binary"
+)
+
+const (
+	// CloudEvents context attributes
+	Attribute_SpecVersion     = "specversion"
+	Attribute_ID              = "id"
+	Attribute_Source          = "source"
+	Attribute_Type            = "type"
+	Attribute_Subject         = "subject"
+	Attribute_Time            = "time"
+	Attribute_DataContentType = "datacontenttype"
+	// CloudEvents context attributes
+)
+
+type CloudEventOptions struct {
+	Mode   string
+	Source string
+}
+
+func IsValidMode(mode string) bool {
+	switch mode {
+	case ModeStructured, ModeBinary:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewSource returns the source of the events published by the named collector. Without a name, it is DefaultSource.
+func NewSource(collectorName string) string {
+	if collectorName == "" {
+		return DefaultSource
+	}
+
+	return fmt.Sprintf("/collector/%s", collectorName)
+}
+
+// NewType returns the type of the events carrying reports in the given report format. An empty report format stands for reports that are not bound to a particular format.
+func NewType(reportFormat string) string {
+	if reportFormat == "" {
+		return TypePrefix
+	}
+
+	return TypePrefix + "." + reportFormat
+}
+
+type CloudEventModel struct {
+	SpecVersion     string
+	ID              string
+	Source          string
+	Type            string
+	Subject         string
+	Time            time.Time
+	DataContentType string
+	Data            []byte
+}
+
+// NewCloudEvent creates the event. An empty source defaults to DefaultSource, because the source is required.
+func NewCloudEvent(source, eventType, subject string, eventTime time.Time, dataContentType string, data []byte) *CloudEventModel {
+	if source == "" {
+		source = DefaultSource
+	}
+
+	return &CloudEventModel{
+		SpecVersion:     SpecVersion,
+		ID:              uuid.NewString(),
+		Source:          source,
+		Type:            eventType,
+		Subject:         subject,
+		Time:            eventTime,
+		DataContentType: dataContentType,
+		Data:            data,
+	}
+}
+
+type structuredCloudEventModel struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            *time.Time      `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      []byte          `json:"data_base64,omitempty"`
+}
+
+// MarshalJSON encodes the event in the structured content mode. JSON data is embedded as is, any other data is base64 encoded.
+func (e *CloudEventModel) MarshalJSON() ([]byte, error) {
+	structuredCloudEvent := &structuredCloudEventModel{
+		SpecVersion:     e.SpecVersion,
+		ID:              e.ID,
+		Source:          e.Source,
+		Type:            e.Type,
+		Subject:         e.Subject,
+		DataContentType: e.DataContentType,
+	}
+
+	if !e.Time.IsZero() {
+		structuredCloudEvent.Time = &e.Time
+	}
+
+	if isJSONContentType(e.DataContentType) && json.Valid(e.Data) {
+		structuredCloudEvent.Data = e.Data
+	} else {
+		structuredCloudEvent.DataBase64 = e.Data
+	}
+
+	return json.Marshal(structuredCloudEvent)
+}
+
+// Attributes returns the context attributes of the event for the binary content mode. The protocol bindings map them to message headers or properties.
+func (e *CloudEventModel) Attributes() map[string]string {
+	attributes := map[string]string{
+		Attribute_SpecVersion: e.SpecVersion,
+		Attribute_ID:          e.ID,
+		Attribute_Source:      e.Source,
+		Attribute_Type:        e.Type,
+	}
+
+	if e.Subject != "" {
+		attributes[Attribute_Subject] = e.Subject
+	}
+
+	if !e.Time.IsZero() {
+		attributes[Attribute_Time] = e.Time.UTC().Format(time.RFC3339Nano)
+	}
+
+	if e.DataContentType != "" {
+		attributes[Attribute_DataContentType] = e.DataContentType
+	}
+
+	return attributes
+}
+
+func isJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"strconv"
 	"time"
@@ -13,10 +15,10 @@ import (
 
 const (
 	// TR-069 and TR-369 report formats
-	ReportFormat_ParameterPerRow    = "ParameterPerRow"
-	ReportFormat_ParameterPerColumn = "ParameterPerColumn"
-	ReportFormat_NameValuePair      = "NameValuePair"
-	ReportFormat_ObjectHierarchy    = "ObjectHierarchy"
+	ReportFormat_ParameterPerRow    = collectorservices.ReportFormat_ParameterPerRow
+	ReportFormat_ParameterPerColumn = collectorservices.ReportFormat_ParameterPerColumn
+	ReportFormat_NameValuePair      = collectorservices.ReportFormat_NameValuePair
+	ReportFormat_ObjectHierarchy    = collectorservices.ReportFormat_ObjectHierarchy
 	// TR-069 and TR-369 report formats
 
 	// TR-069 and TR-369 ParameterPerRow report format columns
@@ -27,6 +29,14 @@ const (
 	// TR-069 and TR-369 ParameterPerRow report format columns
 )
 
+var (
+	ErrInvalidCSVFormat        = errors.New("invalid CSV format")
+	ErrInvalidTimestampFormat  = errors.New("invalid timestamp format")
+	ErrInvalidParameterType    = errors.New("invalid parameter type")
+	ErrInvalidJSONFormat       = errors.New("invalid JSON format")
+	ErrUnsupportedReportFormat = errors.New("unsupported report format")
+)
+
 type CollectorHandler struct {
 	collectorService collectorservices.CollectorService
 }
@@ -42,96 +52,122 @@ func (h *CollectorHandler) Collect(writer http.ResponseWriter, request *http.Req
 	productClass := request.URL.Query().Get("pc")
 	serialNumber := request.URL.Query().Get("sn")
 
-	if reportFormat == ReportFormat_ParameterPerRow {
-		bulkData := &collectorservices.CSVBulkDataModel{
-			ParameterPerRow: []*collectorservices.ParameterPerRowModel{},
-		}
-
-		records, err := csv.NewReader(request.Body).ReadAll()
-
-		if err != nil {
-			http.Error(writer, "Bad Request: Invalid CSV format", http.StatusBadRequest)
+	switch reportFormat {
+	case ReportFormat_ParameterPerRow, ReportFormat_NameValuePair:
+		if err := collect(collectorservices.WithQueryParameters(request.Context(), request.URL.Query()), h.collectorService, reportFormat, oui, productClass, serialNumber, request.Body); err != nil {
+			writeError(writer, err)
 
 			return
 		}
+	case ReportFormat_ParameterPerColumn, ReportFormat_ObjectHierarchy:
+		http.Error(writer, "Bad Request: Unsupported report format "+reportFormat+". The supported report formats are ParameterPerRow and NameValuePair.", http.StatusBadRequest)
+
+		return
+	}
+}
 
-		fields := map[string]int{}
+// writeError responds with the status of the error of the collection - 400 for invalid bulk data, 429 for backpressure and 503 while the collector service is unavailable.
+func writeError(writer http.ResponseWriter, err error) {
+	if errors.Is(err, ErrInvalidCSVFormat) {
+		http.Error(writer, "Bad Request: Invalid CSV format", http.StatusBadRequest)
+	} else if errors.Is(err, ErrInvalidTimestampFormat) {
+		http.Error(writer, "Bad Request: Invalid timestamp format", http.StatusBadRequest)
+	} else if errors.Is(err, ErrInvalidParameterType) {
+		http.Error(writer, "Bad Request: Invalid parameter type", http.StatusBadRequest)
+	} else if errors.Is(err, ErrInvalidJSONFormat) {
+		http.Error(writer, "Bad Request: Invalid JSON format", http.StatusBadRequest)
+	} else if errors.Is(err, ErrDeviceIdentityRequired) {
+		http.Error(writer, "Bad Request: Device identity required", http.StatusBadRequest)
+	} else if errors.Is(err, ErrUnsupportedReportFormat) {
+		http.Error(writer, "Bad Request: Unsupported report format. The supported report formats are ParameterPerRow and NameValuePair.", http.StatusBadRequest)
+	} else if errors.Is(err, collectorservices.ErrBackpressure) {
+		http.Error(writer, "Too Many Requests", http.StatusTooManyRequests)
+	} else if errors.Is(err, collectorservices.ErrUnavailable) {
+		http.Error(writer, "Service Unavailable", http.StatusServiceUnavailable)
+	} else {
+		http.Error(writer, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
 
-		for recordIndex, record := range records {
-			if recordIndex == 0 {
-				for fieldIndex, field := range record {
-					fields[field] = fieldIndex
-				}
-			} else {
-				reportTimestamp, err := strconv.ParseInt(record[fields[ParameterPerRow_ReportTimestamp]], 10, 64)
+// ParseCSVBulkData parses the bulk data of the ParameterPerRow report format.
+func ParseCSVBulkData(reader io.Reader) (*collectorservices.CSVBulkDataModel, error) {
+	bulkData := &collectorservices.CSVBulkDataModel{
+		ParameterPerRow: []*collectorservices.ParameterPerRowModel{},
+	}
 
-				if err != nil {
-					http.Error(writer, "Bad Request: Invalid timestamp format", http.StatusBadRequest)
+	records, err := csv.NewReader(reader).ReadAll()
 
-					return
-				}
+	if err != nil {
+		return nil, ErrInvalidCSVFormat
+	}
 
-				parameterType := record[fields[ParameterPerRow_ParameterType]]
+	fields := map[string]int{}
 
-				if !collectorservices.IsValidParameterType(parameterType) {
-					http.Error(writer, "Bad Request: Invalid parameter type", http.StatusBadRequest)
+	for recordIndex, record := range records {
+		if recordIndex == 0 {
+			for fieldIndex, field := range record {
+				fields[field] = fieldIndex
+			}
+		} else {
+			reportTimestamp, err := strconv.ParseInt(record[fields[ParameterPerRow_ReportTimestamp]], 10, 64)
 
-					return
-				}
+			if err != nil {
+				return nil, ErrInvalidTimestampFormat
+			}
 
-				parameterPerRow := &collectorservices.ParameterPerRowModel{
-					ReportTimestamp: time.Unix(reportTimestamp, 0),
-					ParameterName:   record[fields[ParameterPerRow_ParameterName]],
-					ParameterValue:  record[fields[ParameterPerRow_ParameterValue]],
-					ParameterType:   parameterType,
-				}
+			parameterType := record[fields[ParameterPerRow_ParameterType]]
 
-				bulkData.ParameterPerRow = append(bulkData.ParameterPerRow, parameterPerRow)
+			if !collectorservices.IsValidParameterType(parameterType) {
+				return nil, ErrInvalidParameterType
 			}
-		}
 
-		if err := h.collectorService.CollectCSV(request.Context(), oui, productClass, serialNumber, bulkData); err != nil {
-			if errors.Is(err, collectorservices.ErrBackpressure) {
-				http.Error(writer, "Too Many Requests", http.StatusTooManyRequests)
-			} else {
-				http.Error(writer, "Internal Server Error", http.StatusInternalServerError)
+			parameterPerRow := &collectorservices.ParameterPerRowModel{
+				ReportTimestamp: time.Unix(reportTimestamp, 0),
+				ParameterName:   record[fields[ParameterPerRow_ParameterName]],
+				ParameterValue:  record[fields[ParameterPerRow_ParameterValue]],
+				ParameterType:   parameterType,
 			}
 
-			return
+			bulkData.ParameterPerRow = append(bulkData.ParameterPerRow, parameterPerRow)
 		}
 	}
 
-	if reportFormat == ReportFormat_ParameterPerColumn {
-		http.Error(writer, "Bad Request: Unsupported report format ParameterPerColumn. The supported report formats are ParameterPerRow and NameValuePair.", http.StatusBadRequest)
+	return bulkData, nil
+}
 
-		return
+// ParseJSONBulkData parses the bulk data of the NameValuePair report format.
+func ParseJSONBulkData(reader io.Reader) (*collectorservices.JSONBulkDataModel, error) {
+	bulkData := &collectorservices.JSONBulkDataModel{
+		NameValuePair: &collectorservices.NameValuePairModel{},
 	}
 
-	if reportFormat == ReportFormat_NameValuePair {
-		bulkData := &collectorservices.JSONBulkDataModel{
-			NameValuePair: &collectorservices.NameValuePairModel{},
-		}
+	if err := json.NewDecoder(reader).Decode(&bulkData.NameValuePair); err != nil {
+		return nil, ErrInvalidJSONFormat
+	}
 
-		if err := json.NewDecoder(request.Body).Decode(&bulkData.NameValuePair); err != nil {
-			http.Error(writer, "Bad Request: Invalid JSON format", http.StatusBadRequest)
+	return bulkData, nil
+}
 
-			return
+// collect parses the bulk data of the report format and passes it to the collector service.
+func collect(ctx context.Context, collectorService collectorservices.CollectorService, reportFormat, oui, productClass, serialNumber string, reader io.Reader) error {
+	switch reportFormat {
+	case ReportFormat_ParameterPerRow:
+		bulkData, err := ParseCSVBulkData(reader)
+
+		if err != nil {
+			return err
 		}
 
-		if err := h.collectorService.CollectJSON(request.Context(), oui, productClass, serialNumber, bulkData); err != nil {
-			if errors.Is(err, collectorservices.ErrBackpressure) {
-				http.Error(writer, "Too Many Requests", http.StatusTooManyRequests)
-			} else {
-				http.Error(writer, "Internal Server Error", http.StatusInternalServerError)
-			}
+		return collectorService.CollectCSV(ctx, oui, productClass, serialNumber, bulkData)
+	case ReportFormat_NameValuePair:
+		bulkData, err := ParseJSONBulkData(reader)
 
-			return
+		if err != nil {
+			return err
 		}
-	}
 
-	if reportFormat == ReportFormat_ObjectHierarchy {
-		http.Error(writer, "Bad Request: Unsupported report format ObjectHierarchy. The supported report formats are ParameterPerRow and NameValuePair.", http.StatusBadRequest)
-
-		return
+		return collectorService.CollectJSON(ctx, oui, productClass, serialNumber, bulkData)
+	default:
+		return ErrUnsupportedReportFormat
 	}
 }
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/url"
+	"strings"
+
+	"github.com/dapr/go-sdk/service/common"
+	collectorservices "github.com/zdrgeo/bulk-data-collector/pkg/services"
+)
+
+const (
+	// Metadata of the topic events and query parameters of the service invocations with the device identity and the report format
+	DaprMetadata_OUI          = "oui"
+	DaprMetadata_ProductClass = "pc"
+	DaprMetadata_SerialNumber = "sn"
+	DaprMetadata_ReportFormat = "format"
+	// Metadata of the topic events and query parameters of the service invocations with the device identity and the report format
+)
+
+var (
+	ErrDeviceIdentityRequired = errors.New("device identity required")
+)
+
+// DaprCollectorHandler accepts bulk data that other services forward through Dapr, either as topic events or as service invocations, and feeds it into the collector service.
+type DaprCollectorHandler struct {
+	collectorService collectorservices.CollectorService
+}
+
+func NewDaprCollectorHandler(collectorService collectorservices.CollectorService) *DaprCollectorHandler {
+	return &DaprCollectorHandler{collectorService}
+}
+
+// CollectTopicEvent is a topic event handler. The device identity and the report format are in the metadata of the event. Events with invalid bulk data are dropped and events that failed due to backpressure are retried.
+func (h *DaprCollectorHandler) CollectTopicEvent(ctx context.Context, event *common.TopicEvent) (bool, error) {
+	var data []byte
+
+	switch eventData := event.Data.(type) {
+	case string:
+		data = []byte(eventData)
+	case []byte:
+		data = eventData
+	default:
+		data = event.RawData
+	}
+
+	reportFormat := metadataValue(event.Metadata, DaprMetadata_ReportFormat)
+
+	if reportFormat == "" {
+		reportFormat = contentTypeReportFormat(event.DataContentType)
+	}
+
+	oui := metadataValue(event.Metadata, DaprMetadata_OUI)
+	productClass := metadataValue(event.Metadata, DaprMetadata_ProductClass)
+	serialNumber := metadataValue(event.Metadata, DaprMetadata_SerialNumber)
+
+	if err := h.collect(ctx, reportFormat, oui, productClass, serialNumber, bytes.NewReader(data)); err != nil {
+		retry := errors.Is(err, collectorservices.ErrBackpressure) || errors.Is(err, collectorservices.ErrUnavailable)
+
+		return retry, err
+	}
+
+	return false, nil
+}
+
+// CollectInvocation is a service invocation handler. The device identity and the report format are in the query string of the invocation, like in the requests of the devices.
+func (h *DaprCollectorHandler) CollectInvocation(ctx context.Context, invocation *common.InvocationEvent) (*common.Content, error) {
+	query, err := url.ParseQuery(invocation.QueryString)
+
+	if err != nil {
+		return nil, err
+	}
+
+	reportFormat := query.Get(DaprMetadata_ReportFormat)
+
+	if reportFormat == "" {
+		reportFormat = contentTypeReportFormat(invocation.ContentType)
+	}
+
+	oui := query.Get(DaprMetadata_OUI)
+	productClass := query.Get(DaprMetadata_ProductClass)
+	serialNumber := query.Get(DaprMetadata_SerialNumber)
+
+	if err := h.collect(collectorservices.WithQueryParameters(ctx, query), reportFormat, oui, productClass, serialNumber, bytes.NewReader(invocation.Data)); err != nil {
+		return nil, err
+	}
+
+	return &common.Content{}, nil
+}
+
+func (h *DaprCollectorHandler) collect(ctx context.Context, reportFormat, oui, productClass, serialNumber string, reader io.Reader) error {
+	if oui == "" || productClass == "" || serialNumber == "" {
+		return ErrDeviceIdentityRequired
+	}
+
+	return collect(ctx, h.collectorService, reportFormat, oui, productClass, serialNumber, reader)
+}
+
+// metadataValue looks the metadata up case insensitively, because Dapr delivers the metadata of the topic events over HTTP as headers.
+func metadataValue(metadata map[string]string, name string) string {
+	for key, value := range metadata {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// contentTypeReportFormat infers the report format from the content type, when the report format is not set explicitly.
+func contentTypeReportFormat(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch mediaType {
+	case "text/csv":
+		return ReportFormat_ParameterPerRow
+	case "application/json":
+		return ReportFormat_NameValuePair
+	default:
+		return ""
+	}
+}
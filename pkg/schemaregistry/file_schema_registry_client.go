@@ -0,0 +1,127 @@
+package schemaregistry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+type fileSchemaModel struct {
+	ID         int    `json:"id"`
+	Subject    string `json:"subject"`
+	Version    int    `json:"version"`
+	SchemaType string `json:"schemaType,omitempty"`
+	Schema     string `json:"schema"`
+}
+
+// FileSchemaRegistryClient keeps the registered schemas in a local JSON file. It is a stand-in for a schema registry server in development and test environments.
+type FileSchemaRegistryClient struct {
+	fileName string
+	mutex    sync.Mutex
+}
+
+var _ SchemaRegistryClient = (*FileSchemaRegistryClient)(nil)
+
+func NewFileSchemaRegistryClient(fileName string) *FileSchemaRegistryClient {
+	return &FileSchemaRegistryClient{fileName: fileName}
+}
+
+func (c *FileSchemaRegistryClient) Register(ctx context.Context, subject string, schema *SchemaModel) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	fileSchemas, err := c.load()
+
+	if err != nil {
+		return 0, err
+	}
+
+	id, version := 0, 0
+
+	for _, fileSchema := range fileSchemas {
+		if fileSchema.SchemaType == schema.SchemaType && fileSchema.Schema == schema.Schema {
+			if fileSchema.Subject == subject {
+				return fileSchema.ID, nil
+			}
+
+			id = fileSchema.ID
+		}
+
+		if fileSchema.Subject == subject {
+			version = max(version, fileSchema.Version)
+		}
+	}
+
+	if id == 0 {
+		for _, fileSchema := range fileSchemas {
+			id = max(id, fileSchema.ID)
+		}
+
+		id++
+	}
+
+	fileSchemas = append(fileSchemas, &fileSchemaModel{
+		ID:         id,
+		Subject:    subject,
+		Version:    version + 1,
+		SchemaType: schema.SchemaType,
+		Schema:     schema.Schema,
+	})
+
+	if err := c.save(fileSchemas); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (c *FileSchemaRegistryClient) GetSchema(ctx context.Context, id int) (*SchemaModel, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	fileSchemas, err := c.load()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fileSchema := range fileSchemas {
+		if fileSchema.ID == id {
+			return &SchemaModel{SchemaType: fileSchema.SchemaType, Schema: fileSchema.Schema}, nil
+		}
+	}
+
+	return nil, ErrSchemaNotFound
+}
+
+func (c *FileSchemaRegistryClient) load() ([]*fileSchemaModel, error) {
+	data, err := os.ReadFile(c.fileName)
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []*fileSchemaModel{}, nil
+		}
+
+		return nil, err
+	}
+
+	fileSchemas := []*fileSchemaModel{}
+
+	if err := json.Unmarshal(data, &fileSchemas); err != nil {
+		return nil, err
+	}
+
+	return fileSchemas, nil
+}
+
+func (c *FileSchemaRegistryClient) save(fileSchemas []*fileSchemaModel) error {
+	data, err := json.MarshalIndent(fileSchemas, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(c.fileName, data, 0o644)
+}
@@ -0,0 +1,121 @@
+package schemaregistry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	schemaRegistryContentType = "application/vnd.schemaregistry.v1+json"
+)
+
+type HTTPSchemaRegistryClientOptions struct {
+	URL      string
+	Username string
+	Password string
+}
+
+// HTTPSchemaRegistryClient talks to a Confluent compatible schema registry over its REST API.
+type HTTPSchemaRegistryClient struct {
+	httpClient *http.Client
+	options    *HTTPSchemaRegistryClientOptions
+}
+
+var _ SchemaRegistryClient = (*HTTPSchemaRegistryClient)(nil)
+
+type SchemaRegistryError struct {
+	StatusCode int
+	ErrorCode  int    `json:"error_code"`
+	Message    string `json:"message"`
+}
+
+func (schemaRegistryErr *SchemaRegistryError) Error() string {
+	return fmt.Sprintf("schema registry error %d (%d): %s", schemaRegistryErr.ErrorCode, schemaRegistryErr.StatusCode, schemaRegistryErr.Message)
+}
+
+func NewHTTPSchemaRegistryClient(httpClient *http.Client, options *HTTPSchemaRegistryClientOptions) *HTTPSchemaRegistryClient {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &HTTPSchemaRegistryClient{httpClient: httpClient, options: options}
+}
+
+func (c *HTTPSchemaRegistryClient) Register(ctx context.Context, subject string, schema *SchemaModel) (int, error) {
+	body, err := json.Marshal(schema)
+
+	if err != nil {
+		return 0, err
+	}
+
+	response := &struct {
+		ID int `json:"id"`
+	}{}
+
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/subjects/%s/versions", url.PathEscape(subject)), body, response); err != nil {
+		return 0, err
+	}
+
+	return response.ID, nil
+}
+
+func (c *HTTPSchemaRegistryClient) GetSchema(ctx context.Context, id int) (*SchemaModel, error) {
+	schema := &SchemaModel{}
+
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/schemas/ids/%d", id), nil, schema); err != nil {
+		return nil, err
+	}
+
+	if schema.SchemaType == "" {
+		schema.SchemaType = SchemaTypeAvro
+	}
+
+	return schema, nil
+}
+
+func (c *HTTPSchemaRegistryClient) do(ctx context.Context, method, path string, body []byte, response any) error {
+	request, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.options.URL, "/")+path, bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Accept", schemaRegistryContentType)
+
+	if body != nil {
+		request.Header.Set("Content-Type", schemaRegistryContentType)
+	}
+
+	if c.options.Username != "" {
+		request.SetBasicAuth(c.options.Username, c.options.Password)
+	}
+
+	httpResponse, err := c.httpClient.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		schemaRegistryErr := &SchemaRegistryError{StatusCode: httpResponse.StatusCode}
+
+		if err := json.NewDecoder(httpResponse.Body).Decode(schemaRegistryErr); err != nil {
+			schemaRegistryErr.Message = http.StatusText(httpResponse.StatusCode)
+		}
+
+		if schemaRegistryErr.ErrorCode == 40403 {
+			return ErrSchemaNotFound
+		}
+
+		return schemaRegistryErr
+	}
+
+	return json.NewDecoder(httpResponse.Body).Decode(response)
+}
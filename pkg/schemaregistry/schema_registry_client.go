@@ -0,0 +1,28 @@
+package schemaregistry
+
+import (
+	"context"
+	"errors"
+)
+
+const (
+	SchemaTypeAvro     = "AVRO"
+	SchemaTypeProtobuf = "PROTOBUF"
+	SchemaTypeJSON     = "JSON"
+)
+
+var (
+	ErrInvalidSchemaType = errors.New("invalid schema type")
+	ErrSchemaNotFound    = errors.New("schema not found")
+)
+
+type SchemaModel struct {
+	SchemaType string `json:"schemaType,omitempty"`
+	Schema     string `json:"schema"`
+}
+
+// SchemaRegistryClient registers and looks up schemas by ID, following the Confluent Schema Registry semantics.
+type SchemaRegistryClient interface {
+	Register(ctx context.Context, subject string, schema *SchemaModel) (int, error)
+	GetSchema(ctx context.Context, id int) (*SchemaModel, error)
+}
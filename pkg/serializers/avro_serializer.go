@@ -0,0 +1,150 @@
+package serializers
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/zdrgeo/bulk-data-collector/pkg/schemaregistry"
+	"github.com/zdrgeo/bulk-data-collector/pkg/services"
+)
+
+const (
+	AvroEventSchema = `{
+  "type": "record",
+  "name": "Event",
+  "namespace": "com.github.zdrgeo.bulkdatacollector",
+  "fields": [
+    {"name": "CollectionTime", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "OUI", "type": "string"},
+    {"name": "ProductClass", "type": "string"},
+    {"name": "SerialNumber", "type": "string"},
+    {"name": "Parameters", "type": {"type": "map", "values": ["null", "boolean", "long", "double", "string"]}}
+  ]
+}`
+)
+
+const (
+	// Branches of the Parameters values union
+	avroUnionIndexNull    = 0
+	avroUnionIndexBoolean = 1
+	avroUnionIndexLong    = 2
+	avroUnionIndexDouble  = 3
+	avroUnionIndexString  = 4
+	// Branches of the Parameters values union
+)
+
+// AvroSerializer encodes events with AvroEventSchema in the Confluent wire format. Values of dateTime parameters and unsignedLong values that overflow long are encoded as strings.
+type AvroSerializer struct {
+	schemaID int
+}
+
+var _ Serializer = (*AvroSerializer)(nil)
+
+func NewAvroSerializer(ctx context.Context, schemaRegistryClient schemaregistry.SchemaRegistryClient, options *SerializerOptions) (*AvroSerializer, error) {
+	schema := &schemaregistry.SchemaModel{
+		SchemaType: schemaregistry.SchemaTypeAvro,
+		Schema:     AvroEventSchema,
+	}
+
+	schemaID, err := registerSchema(ctx, schemaRegistryClient, options, schema)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &AvroSerializer{schemaID: schemaID}, nil
+}
+
+func (s *AvroSerializer) ContentType() string {
+	return "avro/binary"
+}
+
+func (s *AvroSerializer) Serialize(ctx context.Context, event *services.EventModel) ([]byte, error) {
+	data := appendWireFormatHeader(make([]byte, 0, 256), s.schemaID)
+
+	data = appendAvroLong(data, event.CollectionTime.UnixMilli())
+	data = appendAvroString(data, event.OUI)
+	data = appendAvroString(data, event.ProductClass)
+	data = appendAvroString(data, event.SerialNumber)
+
+	if len(event.Parameters) != 0 {
+		data = appendAvroLong(data, int64(len(event.Parameters)))
+
+		keys := make([]string, 0, len(event.Parameters))
+
+		for key := range event.Parameters {
+			keys = append(keys, key)
+		}
+
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			value, err := normalizeParameterValue(event.Parameters[key])
+
+			if err != nil {
+				return nil, err
+			}
+
+			data = appendAvroString(data, key)
+			data = appendAvroParameterValue(data, value)
+		}
+	}
+
+	data = appendAvroLong(data, 0)
+
+	return data, nil
+}
+
+func appendAvroParameterValue(data []byte, value any) []byte {
+	switch v := value.(type) {
+	case bool:
+		data = appendAvroLong(data, avroUnionIndexBoolean)
+
+		if v {
+			return append(data, 1)
+		}
+
+		return append(data, 0)
+	case int64:
+		data = appendAvroLong(data, avroUnionIndexLong)
+
+		return appendAvroLong(data, v)
+	case uint64:
+		if int64Value, ok := uint64ToInt64(v); ok {
+			data = appendAvroLong(data, avroUnionIndexLong)
+
+			return appendAvroLong(data, int64Value)
+		}
+
+		data = appendAvroLong(data, avroUnionIndexString)
+
+		return appendAvroString(data, formatUint64(v))
+	case float64:
+		data = appendAvroLong(data, avroUnionIndexDouble)
+
+		return binary.LittleEndian.AppendUint64(data, math.Float64bits(v))
+	case string:
+		data = appendAvroLong(data, avroUnionIndexString)
+
+		return appendAvroString(data, v)
+	case time.Time:
+		data = appendAvroLong(data, avroUnionIndexString)
+
+		return appendAvroString(data, v.Format(time.RFC3339Nano))
+	default:
+		return appendAvroLong(data, avroUnionIndexNull)
+	}
+}
+
+func appendAvroLong(data []byte, value int64) []byte {
+	return binary.AppendVarint(data, value)
+}
+
+func appendAvroString(data []byte, value string) []byte {
+	data = appendAvroLong(data, int64(len(value)))
+
+	return append(data, value...)
+}
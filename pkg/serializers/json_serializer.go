@@ -0,0 +1,24 @@
+package serializers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/zdrgeo/bulk-data-collector/pkg/services"
+)
+
+type JSONSerializer struct{}
+
+var _ Serializer = (*JSONSerializer)(nil)
+
+func NewJSONSerializer() *JSONSerializer {
+	return &JSONSerializer{}
+}
+
+func (s *JSONSerializer) ContentType() string {
+	return "application/json"
+}
+
+func (s *JSONSerializer) Serialize(ctx context.Context, event *services.EventModel) ([]byte, error) {
+	return json.MarshalIndent(event, "", "  ")
+}
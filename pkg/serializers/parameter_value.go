@@ -0,0 +1,52 @@
+package serializers
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+)
+
+// normalizeParameterValue narrows a parameter value, as produced by services.ParseParameterValue or by decoding a JSON report, to one of nil, bool, int64, uint64, float64, string or time.Time.
+func normalizeParameterValue(value any) (any, error) {
+	switch v := value.(type) {
+	case nil, bool, int64, uint64, float64, string, time.Time:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case uint:
+		return uint64(v), nil
+	case uint32:
+		return uint64(v), nil
+	case float32:
+		return float64(v), nil
+	case json.Number:
+		if int64Value, err := v.Int64(); err == nil {
+			return int64Value, nil
+		}
+
+		return v.Float64()
+	default:
+		data, err := json.Marshal(v)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return string(data), nil
+	}
+}
+
+func uint64ToInt64(value uint64) (int64, bool) {
+	if value > math.MaxInt64 {
+		return 0, false
+	}
+
+	return int64(value), true
+}
+
+func formatUint64(value uint64) string {
+	return strconv.FormatUint(value, 10)
+}
@@ -0,0 +1,188 @@
+package serializers
+
+import (
+	"context"
+	"math"
+	"slices"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/zdrgeo/bulk-data-collector/pkg/schemaregistry"
+	"github.com/zdrgeo/bulk-data-collector/pkg/services"
+)
+
+const (
+	ProtobufEventSchema = `syntax = "proto3";
+
+package bulkdatacollector;
+
+import "google/protobuf/timestamp.proto";
+
+message Event {
+  google.protobuf.Timestamp collection_time = 1;
+  string oui = 2;
+  string product_class = 3;
+  string serial_number = 4;
+  map<string, ParameterValue> parameters = 5;
+}
+
+message ParameterValue {
+  oneof kind {
+    bool bool_value = 1;
+    int64 int_value = 2;
+    uint64 uint_value = 3;
+    double double_value = 4;
+    string string_value = 5;
+    google.protobuf.Timestamp time_value = 6;
+  }
+}
+`
+)
+
+const (
+	// Event field numbers
+	protobufEventCollectionTime = 1
+	protobufEventOUI            = 2
+	protobufEventProductClass   = 3
+	protobufEventSerialNumber   = 4
+	protobufEventParameters     = 5
+	// Event field numbers
+
+	// ParameterValue field numbers
+	protobufParameterValueBool   = 1
+	protobufParameterValueInt    = 2
+	protobufParameterValueUint   = 3
+	protobufParameterValueDouble = 4
+	protobufParameterValueString = 5
+	protobufParameterValueTime   = 6
+	// ParameterValue field numbers
+
+	// Map entry and google.protobuf.Timestamp field numbers
+	protobufMapEntryKey      = 1
+	protobufMapEntryValue    = 2
+	protobufTimestampSeconds = 1
+	protobufTimestampNanos   = 2
+	// Map entry and google.protobuf.Timestamp field numbers
+)
+
+// ProtobufSerializer encodes events as the Event message of ProtobufEventSchema in the Confluent wire format.
+type ProtobufSerializer struct {
+	schemaID int
+}
+
+var _ Serializer = (*ProtobufSerializer)(nil)
+
+func NewProtobufSerializer(ctx context.Context, schemaRegistryClient schemaregistry.SchemaRegistryClient, options *SerializerOptions) (*ProtobufSerializer, error) {
+	schema := &schemaregistry.SchemaModel{
+		SchemaType: schemaregistry.SchemaTypeProtobuf,
+		Schema:     ProtobufEventSchema,
+	}
+
+	schemaID, err := registerSchema(ctx, schemaRegistryClient, options, schema)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProtobufSerializer{schemaID: schemaID}, nil
+}
+
+func (s *ProtobufSerializer) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (s *ProtobufSerializer) Serialize(ctx context.Context, event *services.EventModel) ([]byte, error) {
+	data := appendWireFormatHeader(make([]byte, 0, 256), s.schemaID)
+
+	// Message indexes of the Event message - the first message in the schema
+	data = protowire.AppendVarint(data, 0)
+
+	data = protowire.AppendTag(data, protobufEventCollectionTime, protowire.BytesType)
+	data = protowire.AppendBytes(data, appendProtobufTimestamp(nil, event.CollectionTime))
+
+	data = appendProtobufString(data, protobufEventOUI, event.OUI)
+	data = appendProtobufString(data, protobufEventProductClass, event.ProductClass)
+	data = appendProtobufString(data, protobufEventSerialNumber, event.SerialNumber)
+
+	keys := make([]string, 0, len(event.Parameters))
+
+	for key := range event.Parameters {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		value, err := normalizeParameterValue(event.Parameters[key])
+
+		if err != nil {
+			return nil, err
+		}
+
+		entry := appendProtobufString(nil, protobufMapEntryKey, key)
+
+		entry = protowire.AppendTag(entry, protobufMapEntryValue, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, appendProtobufParameterValue(nil, value))
+
+		data = protowire.AppendTag(data, protobufEventParameters, protowire.BytesType)
+		data = protowire.AppendBytes(data, entry)
+	}
+
+	return data, nil
+}
+
+func appendProtobufParameterValue(data []byte, value any) []byte {
+	switch v := value.(type) {
+	case bool:
+		data = protowire.AppendTag(data, protobufParameterValueBool, protowire.VarintType)
+
+		return protowire.AppendVarint(data, protowire.EncodeBool(v))
+	case int64:
+		data = protowire.AppendTag(data, protobufParameterValueInt, protowire.VarintType)
+
+		return protowire.AppendVarint(data, uint64(v))
+	case uint64:
+		data = protowire.AppendTag(data, protobufParameterValueUint, protowire.VarintType)
+
+		return protowire.AppendVarint(data, v)
+	case float64:
+		data = protowire.AppendTag(data, protobufParameterValueDouble, protowire.Fixed64Type)
+
+		return protowire.AppendFixed64(data, math.Float64bits(v))
+	case string:
+		data = protowire.AppendTag(data, protobufParameterValueString, protowire.BytesType)
+
+		return protowire.AppendString(data, v)
+	case time.Time:
+		data = protowire.AppendTag(data, protobufParameterValueTime, protowire.BytesType)
+
+		return protowire.AppendBytes(data, appendProtobufTimestamp(nil, v))
+	default:
+		return data
+	}
+}
+
+func appendProtobufTimestamp(data []byte, value time.Time) []byte {
+	if seconds := value.Unix(); seconds != 0 {
+		data = protowire.AppendTag(data, protobufTimestampSeconds, protowire.VarintType)
+		data = protowire.AppendVarint(data, uint64(seconds))
+	}
+
+	if nanos := value.Nanosecond(); nanos != 0 {
+		data = protowire.AppendTag(data, protobufTimestampNanos, protowire.VarintType)
+		data = protowire.AppendVarint(data, uint64(nanos))
+	}
+
+	return data
+}
+
+func appendProtobufString(data []byte, number protowire.Number, value string) []byte {
+	if value == "" {
+		return data
+	}
+
+	data = protowire.AppendTag(data, number, protowire.BytesType)
+
+	return protowire.AppendString(data, value)
+}
@@ -0,0 +1,115 @@
+package serializers
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/zdrgeo/bulk-data-collector/pkg/schemaregistry"
+	"github.com/zdrgeo/bulk-data-collector/pkg/services"
+)
+
+var (
+	ErrInvalidSerializerKind = errors.New("invalid serializer kind")
+)
+
+const (
+	SerializerKindJSON     = "JSON"
+	SerializerKindAvro     = "Avro"
+	SerializerKindProtobuf = "Protobuf"
+)
+
+const (
+	// Confluent wire format magic byte
+	wireFormatMagicByte = 0
+)
+
+type SchemaRegistryOptions struct {
+	// URL of the HTTP schema registry. If not set, the file schema registry is used.
+	URL      string `json:"URL"`
+	Username string `json:"Username"`
+	Password string `json:"Password"`
+	// File of the file schema registry. Defaults to schema_registry.json.
+	File string `json:"File"`
+}
+
+type SerializerOptions struct {
+	Kind    string `json:"Kind"`
+	Subject string `json:"Subject"`
+	// RegisterTimeout is the timeout of the registration of the event schema. Defaults to 30 seconds.
+	RegisterTimeout time.Duration `json:"RegisterTimeout"`
+	// SchemaRegistry configures the schema registry client of NewSerializerWithSchemaRegistry.
+	SchemaRegistry *SchemaRegistryOptions `json:"SchemaRegistry"`
+}
+
+type Serializer interface {
+	ContentType() string
+	Serialize(ctx context.Context, event *services.EventModel) ([]byte, error)
+}
+
+func NewSerializer(ctx context.Context, schemaRegistryClient schemaregistry.SchemaRegistryClient, options *SerializerOptions) (Serializer, error) {
+	switch options.Kind {
+	case "", SerializerKindJSON:
+		return NewJSONSerializer(), nil
+	case SerializerKindAvro:
+		return NewAvroSerializer(ctx, schemaRegistryClient, options)
+	case SerializerKindProtobuf:
+		return NewProtobufSerializer(ctx, schemaRegistryClient, options)
+	}
+
+	return nil, ErrInvalidSerializerKind
+}
+
+// NewSerializerWithSchemaRegistry creates the serializer with the HTTP schema registry client of the schema registry URL, or else with the file schema registry client of the schema registry file.
+func NewSerializerWithSchemaRegistry(ctx context.Context, options *SerializerOptions) (Serializer, error) {
+	schemaRegistryOptions := options.SchemaRegistry
+
+	if schemaRegistryOptions == nil {
+		schemaRegistryOptions = &SchemaRegistryOptions{}
+	}
+
+	var schemaRegistryClient schemaregistry.SchemaRegistryClient
+
+	if schemaRegistryOptions.URL != "" {
+		schemaRegistryClientOptions := &schemaregistry.HTTPSchemaRegistryClientOptions{
+			URL:      schemaRegistryOptions.URL,
+			Username: schemaRegistryOptions.Username,
+			Password: schemaRegistryOptions.Password,
+		}
+
+		schemaRegistryClient = schemaregistry.NewHTTPSchemaRegistryClient(nil, schemaRegistryClientOptions)
+	} else {
+		schemaRegistryFile := schemaRegistryOptions.File
+
+		if schemaRegistryFile == "" {
+			schemaRegistryFile = "schema_registry.json"
+		}
+
+		schemaRegistryClient = schemaregistry.NewFileSchemaRegistryClient(schemaRegistryFile)
+	}
+
+	return NewSerializer(ctx, schemaRegistryClient, options)
+}
+
+// registerSchema registers the event schema under the subject, within the register timeout.
+func registerSchema(ctx context.Context, schemaRegistryClient schemaregistry.SchemaRegistryClient, options *SerializerOptions, schema *schemaregistry.SchemaModel) (int, error) {
+	registerTimeout := 30 * time.Second
+
+	if options.RegisterTimeout > 0 {
+		registerTimeout = options.RegisterTimeout
+	}
+
+	registerCtx, cancel := context.WithTimeout(ctx, registerTimeout)
+
+	defer cancel()
+
+	return schemaRegistryClient.Register(registerCtx, options.Subject, schema)
+}
+
+func appendWireFormatHeader(data []byte, schemaID int) []byte {
+	data = append(data, wireFormatMagicByte)
+	data = binary.BigEndian.AppendUint32(data, uint32(schemaID))
+
+	return data
+}
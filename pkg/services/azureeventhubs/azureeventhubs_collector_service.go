@@ -6,15 +6,20 @@ import (
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azeventhubs"
+	"github.com/google/uuid"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 
+	"github.com/zdrgeo/bulk-data-collector/pkg/cloudevents"
+	"github.com/zdrgeo/bulk-data-collector/pkg/serializers"
 	"github.com/zdrgeo/bulk-data-collector/pkg/services"
 )
 
@@ -22,6 +27,15 @@ import (
 
 const (
 	meterName = "collector"
+
+	// AMQP protocol binding prefix of the CloudEvents attributes (https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/bindings/amqp-protocol-binding.md)
+	cloudEventsPropertyPrefix = "cloudEvents:"
+
+	// Application properties of the chunk events of a report that does not fit in a single event
+	chunkCorrelationIDProperty = "ChunkCorrelationID"
+	chunkIndexProperty         = "ChunkIndex"
+	chunkCountProperty         = "ChunkCount"
+	// Application properties of the chunk events of a report that does not fit in a single event
 )
 
 type AzureEventHubsEventModel struct {
@@ -39,25 +53,54 @@ type AzureEventHubsEventBatchModel struct {
 type AzureEventHubsCollectorServiceOptions struct {
 	PartitionQueueLimit     int
 	PartitionProducersCount int
+	PartitionBatchInterval  time.Duration
+	Serializer              serializers.Serializer
+	CloudEvent              *cloudevents.CloudEventOptions
+	// SynchronousAcknowledgement makes Collect, CollectCSV and CollectJSON wait until the batches containing the reports are sent to Event Hubs.
+	SynchronousAcknowledgement bool
+	AcknowledgementTimeout     time.Duration
+	// PartitionProducersScaling scales the partition producers of each partition queue between bounds instead of running a fixed PartitionProducersCount.
+	PartitionProducersScaling *PartitionProducersScalingOptions
+}
+
+type partitionQueueItem struct {
+	event           *AzureEventHubsEventModel
+	reportFormat    string
+	acknowledgement chan error
+}
+
+type partitionBatch struct {
+	eventDataBatch   AzureEventHubsEventDataBatch
+	acknowledgements []chan error
+	// sent tells that the batch was sent, or failed to send, and its events were acknowledged
+	sent bool
 }
 
 type partitionQueue struct {
-	partitionID string
-	queue       chan *AzureEventHubsEventModel
+	partitionID     string
+	queue           chan *partitionQueueItem
+	sendCount       atomic.Int64
+	sendDuration    atomic.Int64
+	throttlingCount atomic.Int64
 }
 
 type AzureEventHubsCollectorService struct {
-	producerClient  *azeventhubs.ProducerClient
-	options         *AzureEventHubsCollectorServiceOptions
-	partitionQueues []*partitionQueue
-	queueCounter    metric.Int64UpDownCounter
-	batchCounter    metric.Int64Counter
-	eventCounter    metric.Int64Counter
+	producer            AzureEventHubsProducer
+	options             *AzureEventHubsCollectorServiceOptions
+	serializer          serializers.Serializer
+	partitionQueues     []*partitionQueue
+	queueCounter        metric.Int64UpDownCounter
+	batchCounter        metric.Int64Counter
+	eventCounter        metric.Int64Counter
+	chunkedEventCounter metric.Int64Counter
+	droppedEventCounter metric.Int64Counter
+	producersGauge      metric.Int64Gauge
+	scalingCounter      metric.Int64Counter
 }
 
 var _ services.CollectorService = (*AzureEventHubsCollectorService)(nil)
 
-func NewAzureEventHubsCollectorService(producerClient *azeventhubs.ProducerClient, options *AzureEventHubsCollectorServiceOptions) (*AzureEventHubsCollectorService, error) {
+func NewAzureEventHubsCollectorService(producer AzureEventHubsProducer, options *AzureEventHubsCollectorServiceOptions) (*AzureEventHubsCollectorService, error) {
 	meter := otel.Meter(meterName)
 
 	queueCounter, err := meter.Int64UpDownCounter("partition_queue_counter", metric.WithDescription("Partition queue counter"), metric.WithUnit("count"))
@@ -78,15 +121,44 @@ func NewAzureEventHubsCollectorService(producerClient *azeventhubs.ProducerClien
 		return nil, err
 	}
 
+	chunkedEventCounter, err := meter.Int64Counter("partition_chunked_event_counter", metric.WithDescription("Partition chunked event counter"), metric.WithUnit("count"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	droppedEventCounter, err := meter.Int64Counter("partition_dropped_event_counter", metric.WithDescription("Partition dropped event counter"), metric.WithUnit("count"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	producersGauge, err := meter.Int64Gauge("partition_producers_gauge", metric.WithDescription("Partition producers gauge"), metric.WithUnit("count"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	scalingCounter, err := meter.Int64Counter("partition_producers_scaling_counter", metric.WithDescription("Partition producers scaling counter"), metric.WithUnit("count"))
+
+	if err != nil {
+		return nil, err
+	}
+
 	partitionQueueLimit := 1_000
+	var serializer serializers.Serializer = serializers.NewJSONSerializer()
 
 	if options != nil {
 		if options.PartitionQueueLimit > 0 {
 			partitionQueueLimit = options.PartitionQueueLimit
 		}
+
+		if options.Serializer != nil {
+			serializer = options.Serializer
+		}
 	}
 
-	eventHubProperties, err := producerClient.GetEventHubProperties(context.Background(), nil)
+	eventHubProperties, err := producer.GetEventHubProperties(context.Background(), nil)
 
 	if err != nil {
 		return nil, err
@@ -95,15 +167,17 @@ func NewAzureEventHubsCollectorService(producerClient *azeventhubs.ProducerClien
 	partitionQueues := make([]*partitionQueue, 0, len(eventHubProperties.PartitionIDs))
 
 	for _, partitionID := range eventHubProperties.PartitionIDs {
-		partitionQueue := &partitionQueue{partitionID: partitionID, queue: make(chan *AzureEventHubsEventModel, partitionQueueLimit)}
+		partitionQueue := &partitionQueue{partitionID: partitionID, queue: make(chan *partitionQueueItem, partitionQueueLimit)}
 
 		partitionQueues = append(partitionQueues, partitionQueue)
 	}
 
-	return &AzureEventHubsCollectorService{producerClient: producerClient, options: options, partitionQueues: partitionQueues, queueCounter: queueCounter, batchCounter: batchCounter, eventCounter: eventCounter}, nil
+	return &AzureEventHubsCollectorService{producer: producer, options: options, serializer: serializer, partitionQueues: partitionQueues, queueCounter: queueCounter, batchCounter: batchCounter, eventCounter: eventCounter, chunkedEventCounter: chunkedEventCounter, droppedEventCounter: droppedEventCounter, producersGauge: producersGauge, scalingCounter: scalingCounter}, nil
 }
 
 func (s *AzureEventHubsCollectorService) Collect(ctx context.Context, oui, productClass, serialNumber string, data *services.DataModel) error {
+	acknowledgements := make([]chan error, 0, len(data.Reports))
+
 	for _, report := range data.Reports {
 		event := &AzureEventHubsEventModel{
 			CollectionTime: report.CollectionTime,
@@ -117,12 +191,16 @@ func (s *AzureEventHubsCollectorService) Collect(ctx context.Context, oui, produ
 			event.Parameters[key] = value
 		}
 
-		if err := s.enqueue(ctx, event); err != nil {
+		acknowledgement, err := s.enqueue(ctx, event, "")
+
+		if err != nil {
 			return err
 		}
+
+		acknowledgements = append(acknowledgements, acknowledgement)
 	}
 
-	return nil
+	return s.await(ctx, acknowledgements)
 }
 
 func (s *AzureEventHubsCollectorService) CollectCSV(ctx context.Context, oui, productClass, serialNumber string, bulkData *services.CSVBulkDataModel) error {
@@ -132,6 +210,8 @@ func (s *AzureEventHubsCollectorService) CollectCSV(ctx context.Context, oui, pr
 		reports[parameterPerRow.ReportTimestamp] = append(reports[parameterPerRow.ReportTimestamp], parameterPerRow)
 	}
 
+	acknowledgements := make([]chan error, 0, len(reports))
+
 	for reportTimestamp, report := range reports {
 		event := &AzureEventHubsEventModel{
 			CollectionTime: reportTimestamp,
@@ -151,15 +231,21 @@ func (s *AzureEventHubsCollectorService) CollectCSV(ctx context.Context, oui, pr
 			event.Parameters[parameterPerRow.ParameterName] = value
 		}
 
-		if err := s.enqueue(ctx, event); err != nil {
+		acknowledgement, err := s.enqueue(ctx, event, services.ReportFormat_ParameterPerRow)
+
+		if err != nil {
 			return err
 		}
+
+		acknowledgements = append(acknowledgements, acknowledgement)
 	}
 
-	return nil
+	return s.await(ctx, acknowledgements)
 }
 
 func (s *AzureEventHubsCollectorService) CollectJSON(ctx context.Context, oui, productClass, serialNumber string, bulkData *services.JSONBulkDataModel) error {
+	acknowledgements := []chan error{}
+
 	if bulkData.NameValuePair != nil {
 		for _, report := range bulkData.NameValuePair.Report {
 			event := &AzureEventHubsEventModel{
@@ -174,13 +260,17 @@ func (s *AzureEventHubsCollectorService) CollectJSON(ctx context.Context, oui, p
 				event.Parameters[key] = value
 			}
 
-			if err := s.enqueue(ctx, event); err != nil {
+			acknowledgement, err := s.enqueue(ctx, event, services.ReportFormat_NameValuePair)
+
+			if err != nil {
 				return err
 			}
+
+			acknowledgements = append(acknowledgements, acknowledgement)
 		}
 	}
 
-	return nil
+	return s.await(ctx, acknowledgements)
 }
 
 type RunError struct {
@@ -217,6 +307,10 @@ func (s *AzureEventHubsCollectorService) Run(ctx context.Context) error {
 		partitonProducerCtx = ctx
 	}
 
+	if s.options != nil && s.options.PartitionProducersScaling != nil {
+		return s.runScaling(partitonProducerCtx, partitionProducersCount)
+	}
+
 	partitionProducerErrs := make(chan error, len(s.partitionQueues)*partitionProducersCount)
 
 	partitionProducerGroup := sync.WaitGroup{}
@@ -228,7 +322,7 @@ func (s *AzureEventHubsCollectorService) Run(ctx context.Context) error {
 			go func() {
 				defer partitionProducerGroup.Done()
 
-				partitionProducerErrs <- s.produce(partitonProducerCtx, partitionQueue)
+				partitionProducerErrs <- s.produce(partitonProducerCtx, partitionQueue, nil)
 			}()
 		}
 	}
@@ -254,9 +348,36 @@ func (s *AzureEventHubsCollectorService) Run(ctx context.Context) error {
 	return nil
 }
 
-func (s *AzureEventHubsCollectorService) enqueue(ctx context.Context, event *AzureEventHubsEventModel) error {
+func (s *AzureEventHubsCollectorService) runScaling(ctx context.Context, partitionProducersCount int) error {
+	partitionScalerErrs := make(chan []error, len(s.partitionQueues))
+
+	for _, partitionQueue := range s.partitionQueues {
+		scaler := newPartitionProducersScaler(s, partitionQueue, s.options.PartitionProducersScaling)
+
+		go func() {
+			partitionScalerErrs <- scaler.run(ctx, partitionProducersCount)
+		}()
+	}
+
+	errs := []error{}
+
+	for range s.partitionQueues {
+		errs = append(errs, <-partitionScalerErrs...)
+	}
+
+	if len(errs) != 0 {
+		return &RunError{
+			PartitionProducerErrs: errs,
+		}
+	}
+
+	return nil
+}
+
+// enqueue adds the event to the queue of its partition. In synchronous acknowledgement mode, it returns a channel that receives the result of sending the event.
+func (s *AzureEventHubsCollectorService) enqueue(ctx context.Context, event *AzureEventHubsEventModel, reportFormat string) (chan error, error) {
 	if len(s.partitionQueues) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	deviceName := fmt.Sprintf("%s-%s-%s", event.OUI, event.ProductClass, event.SerialNumber)
@@ -271,123 +392,383 @@ func (s *AzureEventHubsCollectorService) enqueue(ctx context.Context, event *Azu
 
 	partitionQueue := s.partitionQueues[partitionQueueIndex]
 
+	item := &partitionQueueItem{event: event, reportFormat: reportFormat}
+
+	if s.options != nil && s.options.SynchronousAcknowledgement {
+		item.acknowledgement = make(chan error, 1)
+	}
+
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
-	case partitionQueue.queue <- event:
+		return nil, ctx.Err()
+	case partitionQueue.queue <- item:
 		s.queueCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("partition", partitionQueue.partitionID)))
 		// default:
 		// 	return ErrBackpressure
 	}
 
+	return item.acknowledgement, nil
+}
+
+// await waits for the acknowledgements of the enqueued events. It fails with services.ErrUnavailable if any of the events is not sent within the acknowledgement timeout.
+func (s *AzureEventHubsCollectorService) await(ctx context.Context, acknowledgements []chan error) error {
+	if s.options == nil || !s.options.SynchronousAcknowledgement {
+		return nil
+	}
+
+	acknowledgementTimeout := 30 * time.Second
+
+	if s.options.AcknowledgementTimeout > 0 {
+		acknowledgementTimeout = s.options.AcknowledgementTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, acknowledgementTimeout)
+
+	defer cancel()
+
+	for _, acknowledgement := range acknowledgements {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", services.ErrUnavailable, ctx.Err())
+		case err := <-acknowledgement:
+			if err != nil {
+				return fmt.Errorf("%w: %w", services.ErrUnavailable, err)
+			}
+		}
+	}
+
 	return nil
 }
 
-func (s *AzureEventHubsCollectorService) produce(ctx context.Context, partitionQueue *partitionQueue) error {
+func acknowledge(acknowledgements []chan error, err error) {
+	for _, acknowledgement := range acknowledgements {
+		if acknowledgement != nil {
+			acknowledgement <- err
+		}
+	}
+}
+
+// produce dequeues the events of the partition queue and sends them in batches until the context is done or the stop channel is closed.
+func (s *AzureEventHubsCollectorService) produce(ctx context.Context, partitionQueue *partitionQueue, stop <-chan struct{}) error {
+	partitionBatchInterval := 1 * time.Minute
+
+	if s.options != nil {
+		if s.options.PartitionBatchInterval > 0 {
+			partitionBatchInterval = s.options.PartitionBatchInterval
+		}
+	}
+
 	eventDataBatchOptions := &azeventhubs.EventDataBatchOptions{
 		PartitionID: &partitionQueue.partitionID,
 	}
 
-	eventDataBatch, err := s.producerClient.NewEventDataBatch(ctx, eventDataBatchOptions)
+	batch, err := s.newPartitionBatch(ctx, eventDataBatchOptions)
 
 	if err != nil {
 		return err
 	}
 
+	ticker := time.NewTicker(partitionBatchInterval)
+
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
-			numEvents := eventDataBatch.NumEvents()
-
-			if numEvents != 0 {
-				if err := s.producerClient.SendEventDataBatch(ctx, eventDataBatch, nil); err != nil {
-					return err
-				}
-
-				s.batchCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("partition", partitionQueue.partitionID)))
-				s.eventCounter.Add(ctx, int64(numEvents), metric.WithAttributes(attribute.String("partition", partitionQueue.partitionID)))
+			if err := s.send(ctx, partitionQueue, batch); err != nil {
+				return err
 			}
 
 			return ctx.Err()
 
-		case <-time.Tick(1 * time.Minute):
-			numEvents := eventDataBatch.NumEvents()
+		case <-stop:
+			return s.send(ctx, partitionQueue, batch)
 
-			if numEvents != 0 {
-				if err := s.producerClient.SendEventDataBatch(ctx, eventDataBatch, nil); err != nil {
+		case <-ticker.C:
+			if batch.eventDataBatch.NumEvents() != 0 {
+				if err := s.send(ctx, partitionQueue, batch); err != nil {
 					return err
 				}
 
-				s.batchCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("partition", partitionQueue.partitionID)))
-				s.eventCounter.Add(ctx, int64(numEvents), metric.WithAttributes(attribute.String("partition", partitionQueue.partitionID)))
-
-				newEventDataBatch, err := s.producerClient.NewEventDataBatch(ctx, eventDataBatchOptions)
+				newBatch, err := s.newPartitionBatch(ctx, eventDataBatchOptions)
 
 				if err != nil {
 					return err
 				}
 
-				eventDataBatch = newEventDataBatch
+				batch = newBatch
 			}
 
-		case event, ok := <-partitionQueue.queue:
+		case item, ok := <-partitionQueue.queue:
 			if !ok {
-				numEvents := eventDataBatch.NumEvents()
-
-				if numEvents != 0 {
-					if err := s.producerClient.SendEventDataBatch(ctx, eventDataBatch, nil); err != nil {
-						return err
-					}
-
-					s.batchCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("partition", partitionQueue.partitionID)))
-					s.eventCounter.Add(ctx, int64(numEvents), metric.WithAttributes(attribute.String("partition", partitionQueue.partitionID)))
-				}
-
-				return nil
+				return s.send(ctx, partitionQueue, batch)
 			}
 
 			s.queueCounter.Add(ctx, -1, metric.WithAttributes(attribute.String("partition", partitionQueue.partitionID)))
 
-			body, err := json.MarshalIndent(event, "", "  ")
+			eventData, err := s.newEventData(ctx, item)
 
 			if err != nil {
-				return err
-			}
+				// An event that cannot be serialized fails alone, rather than the partition producer
+				acknowledge([]chan error{item.acknowledgement}, err)
+
+				s.droppedEventCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("partition", partitionQueue.partitionID)))
 
-			eventData := &azeventhubs.EventData{
-				Body: body,
+				continue
 			}
 
-			if err := eventDataBatch.AddEventData(eventData, nil); err != nil {
+			if batch, err = s.add(ctx, partitionQueue, batch, eventDataBatchOptions, eventData); err != nil {
 				if !errors.Is(err, azeventhubs.ErrEventDataTooLarge) {
-					return err
+					acknowledge([]chan error{item.acknowledgement}, err)
+
+					return s.abort(ctx, partitionQueue, batch, err)
 				}
 
-				numEvents := eventDataBatch.NumEvents()
+				// The event does not fit even in an empty batch, so it is split into chunks
+				chunkEventDatas, err := s.newChunkEventDatas(ctx, item, eventDataBatchOptions)
 
-				if numEvents == 0 {
-					return err
+				if err != nil {
+					acknowledge([]chan error{item.acknowledgement}, err)
+
+					s.droppedEventCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("partition", partitionQueue.partitionID)))
+
+					continue
 				}
 
-				if err := s.producerClient.SendEventDataBatch(ctx, eventDataBatch, nil); err != nil {
-					return err
+				for _, chunkEventData := range chunkEventDatas {
+					if batch, err = s.add(ctx, partitionQueue, batch, eventDataBatchOptions, chunkEventData); err != nil {
+						acknowledge([]chan error{item.acknowledgement}, err)
+
+						return s.abort(ctx, partitionQueue, batch, err)
+					}
 				}
 
-				s.batchCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("partition", partitionQueue.partitionID)))
-				s.eventCounter.Add(ctx, int64(numEvents), metric.WithAttributes(attribute.String("partition", partitionQueue.partitionID)))
+				s.chunkedEventCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("partition", partitionQueue.partitionID)))
+			}
 
-				newEventDataBatch, err := s.producerClient.NewEventDataBatch(ctx, eventDataBatchOptions)
+			// The event is acknowledged along with the batch containing the event or its last chunk
+			batch.acknowledgements = append(batch.acknowledgements, item.acknowledgement)
 
-				if err != nil {
+			// In synchronous acknowledgement mode, the batch is sent as soon as the queue is drained instead of after the batch interval, so the devices are not kept waiting
+			if item.acknowledgement != nil && len(partitionQueue.queue) == 0 {
+				if err := s.send(ctx, partitionQueue, batch); err != nil {
 					return err
 				}
 
-				if err := newEventDataBatch.AddEventData(eventData, nil); err != nil {
+				newBatch, err := s.newPartitionBatch(ctx, eventDataBatchOptions)
+
+				if err != nil {
 					return err
 				}
 
-				eventDataBatch = newEventDataBatch
+				batch = newBatch
+			}
+		}
+	}
+}
+
+func (s *AzureEventHubsCollectorService) newPartitionBatch(ctx context.Context, eventDataBatchOptions *azeventhubs.EventDataBatchOptions) (*partitionBatch, error) {
+	eventDataBatch, err := s.producer.NewEventDataBatch(ctx, eventDataBatchOptions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &partitionBatch{eventDataBatch: eventDataBatch}, nil
+}
+
+// add adds the event data to the batch. If the batch is full, it is sent and the event data is added to a new batch, which is returned instead.
+func (s *AzureEventHubsCollectorService) add(ctx context.Context, partitionQueue *partitionQueue, batch *partitionBatch, eventDataBatchOptions *azeventhubs.EventDataBatchOptions, eventData *azeventhubs.EventData) (*partitionBatch, error) {
+	err := batch.eventDataBatch.AddEventData(eventData, nil)
+
+	if err == nil || !errors.Is(err, azeventhubs.ErrEventDataTooLarge) || batch.eventDataBatch.NumEvents() == 0 {
+		return batch, err
+	}
+
+	if err := s.send(ctx, partitionQueue, batch); err != nil {
+		return batch, err
+	}
+
+	newBatch, err := s.newPartitionBatch(ctx, eventDataBatchOptions)
+
+	if err != nil {
+		return batch, err
+	}
+
+	return newBatch, newBatch.eventDataBatch.AddEventData(eventData, nil)
+}
+
+// abort sends the events already in the batch, so they are neither lost nor left unacknowledged when the partition producer fails, and returns the error of the failure.
+func (s *AzureEventHubsCollectorService) abort(ctx context.Context, partitionQueue *partitionQueue, batch *partitionBatch, err error) error {
+	s.send(ctx, partitionQueue, batch)
+
+	return err
+}
+
+// send sends the batch and acknowledges its events with the result. A batch is sent at most once.
+func (s *AzureEventHubsCollectorService) send(ctx context.Context, partitionQueue *partitionQueue, batch *partitionBatch) error {
+	if batch.sent {
+		return nil
+	}
+
+	batch.sent = true
+
+	numEvents := batch.eventDataBatch.NumEvents()
+
+	if numEvents == 0 {
+		acknowledge(batch.acknowledgements, nil)
+
+		return nil
+	}
+
+	sendTime := time.Now()
+
+	err := s.producer.SendEventDataBatch(ctx, batch.eventDataBatch, nil)
+
+	partitionQueue.sendCount.Add(1)
+	partitionQueue.sendDuration.Add(int64(time.Since(sendTime)))
+
+	if err != nil {
+		if IsThrottlingError(err) {
+			partitionQueue.throttlingCount.Add(1)
+		}
+
+		acknowledge(batch.acknowledgements, err)
+
+		return err
+	}
+
+	acknowledge(batch.acknowledgements, nil)
+
+	s.batchCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("partition", partitionQueue.partitionID)))
+	s.eventCounter.Add(ctx, int64(numEvents), metric.WithAttributes(attribute.String("partition", partitionQueue.partitionID)))
+
+	return nil
+}
+
+func (s *AzureEventHubsCollectorService) newEventData(ctx context.Context, item *partitionQueueItem) (*azeventhubs.EventData, error) {
+	body, err := s.serializer.Serialize(ctx, (*services.EventModel)(item.event))
+
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := s.serializer.ContentType()
+
+	if s.options == nil || s.options.CloudEvent == nil {
+		return &azeventhubs.EventData{Body: body, ContentType: &contentType}, nil
+	}
+
+	deviceName := fmt.Sprintf("%s-%s-%s", item.event.OUI, item.event.ProductClass, item.event.SerialNumber)
+
+	cloudEvent := cloudevents.NewCloudEvent(s.options.CloudEvent.Source, cloudevents.NewType(item.reportFormat), deviceName, item.event.CollectionTime, contentType, body)
+
+	if s.options.CloudEvent.Mode == cloudevents.ModeBinary {
+		properties := map[string]any{}
+
+		for name, value := range cloudEvent.Attributes() {
+			if name != cloudevents.Attribute_DataContentType {
+				properties[cloudEventsPropertyPrefix+name] = value
 			}
 		}
+
+		return &azeventhubs.EventData{Body: body, ContentType: &contentType, Properties: properties}, nil
+	}
+
+	structuredBody, err := json.Marshal(cloudEvent)
+
+	if err != nil {
+		return nil, err
 	}
+
+	structuredContentType := cloudevents.StructuredContentType
+
+	return &azeventhubs.EventData{Body: structuredBody, ContentType: &structuredContentType}, nil
+}
+
+// newChunkEventDatas splits the parameters of the event into the smallest number of chunk events that fit in an empty batch. The chunk events carry the collection time and the device identity of the event, along with correlation ID, chunk index and chunk count properties, so the event can be reassembled downstream.
+func (s *AzureEventHubsCollectorService) newChunkEventDatas(ctx context.Context, item *partitionQueueItem, eventDataBatchOptions *azeventhubs.EventDataBatchOptions) ([]*azeventhubs.EventData, error) {
+	parameterNames := make([]string, 0, len(item.event.Parameters))
+
+	for parameterName := range item.event.Parameters {
+		parameterNames = append(parameterNames, parameterName)
+	}
+
+	if len(parameterNames) < 2 {
+		return nil, azeventhubs.ErrEventDataTooLarge
+	}
+
+	slices.Sort(parameterNames)
+
+	correlationID := uuid.NewString()
+
+	// The chunk counts are tried in order, since the reports rarely exceed the maximum event size many times over
+	for chunkCount := 2; chunkCount <= len(parameterNames); chunkCount++ {
+		chunkEventDatas := make([]*azeventhubs.EventData, 0, chunkCount)
+
+		for chunkIndex := range chunkCount {
+			chunkParameterNames := parameterNames[chunkIndex*len(parameterNames)/chunkCount : (chunkIndex+1)*len(parameterNames)/chunkCount]
+
+			chunkEvent := &AzureEventHubsEventModel{
+				CollectionTime: item.event.CollectionTime,
+				OUI:            item.event.OUI,
+				ProductClass:   item.event.ProductClass,
+				SerialNumber:   item.event.SerialNumber,
+				Parameters:     make(map[string]any, len(chunkParameterNames)),
+			}
+
+			for _, parameterName := range chunkParameterNames {
+				chunkEvent.Parameters[parameterName] = item.event.Parameters[parameterName]
+			}
+
+			chunkEventData, err := s.newEventData(ctx, &partitionQueueItem{event: chunkEvent, reportFormat: item.reportFormat})
+
+			if err != nil {
+				return nil, err
+			}
+
+			if chunkEventData.Properties == nil {
+				chunkEventData.Properties = map[string]any{}
+			}
+
+			chunkEventData.Properties[chunkCorrelationIDProperty] = correlationID
+			chunkEventData.Properties[chunkIndexProperty] = int32(chunkIndex)
+			chunkEventData.Properties[chunkCountProperty] = int32(chunkCount)
+
+			chunkEventDatas = append(chunkEventDatas, chunkEventData)
+		}
+
+		fit, err := s.fit(ctx, chunkEventDatas, eventDataBatchOptions)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if fit {
+			return chunkEventDatas, nil
+		}
+	}
+
+	return nil, azeventhubs.ErrEventDataTooLarge
+}
+
+// fit checks whether each of the event datas fits in an empty batch.
+func (s *AzureEventHubsCollectorService) fit(ctx context.Context, eventDatas []*azeventhubs.EventData, eventDataBatchOptions *azeventhubs.EventDataBatchOptions) (bool, error) {
+	for _, eventData := range eventDatas {
+		eventDataBatch, err := s.producer.NewEventDataBatch(ctx, eventDataBatchOptions)
+
+		if err != nil {
+			return false, err
+		}
+
+		if err := eventDataBatch.AddEventData(eventData, nil); err != nil {
+			if errors.Is(err, azeventhubs.ErrEventDataTooLarge) {
+				return false, nil
+			}
+
+			return false, err
+		}
+	}
+
+	return true, nil
 }
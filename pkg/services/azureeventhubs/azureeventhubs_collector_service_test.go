@@ -0,0 +1,439 @@
+package azureeventhubs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azeventhubs"
+
+	"github.com/zdrgeo/bulk-data-collector/pkg/serializers"
+	"github.com/zdrgeo/bulk-data-collector/pkg/services"
+)
+
+func newTestCollectorService(t *testing.T, producerOptions *FakeAzureEventHubsProducerOptions, options *AzureEventHubsCollectorServiceOptions) (*AzureEventHubsCollectorService, *FakeAzureEventHubsProducer) {
+	t.Helper()
+
+	producer := NewFakeAzureEventHubsProducer(producerOptions)
+
+	collectorService, err := NewAzureEventHubsCollectorService(producer, options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return collectorService, producer
+}
+
+func newTestEvent(serialNumber string, parametersCount, valueLength int) *AzureEventHubsEventModel {
+	event := &AzureEventHubsEventModel{
+		CollectionTime: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		OUI:            "OUI",
+		ProductClass:   "ProductClass",
+		SerialNumber:   serialNumber,
+		Parameters:     make(map[string]any, parametersCount),
+	}
+
+	for parameterIndex := range parametersCount {
+		event.Parameters[fmt.Sprintf("Device.Parameter%d", parameterIndex)] = strings.Repeat("x", valueLength)
+	}
+
+	return event
+}
+
+// produceAll closes the partition queue, so produce sends the enqueued events and returns.
+func produceAll(ctx context.Context, collectorService *AzureEventHubsCollectorService, partitionQueue *partitionQueue) error {
+	close(partitionQueue.queue)
+
+	return collectorService.produce(ctx, partitionQueue, nil)
+}
+
+// failingSerializer fails to serialize the events of a serial number.
+type failingSerializer struct {
+	serializers.Serializer
+	serialNumber string
+	err          error
+}
+
+func (s *failingSerializer) Serialize(ctx context.Context, event *services.EventModel) ([]byte, error) {
+	if event.SerialNumber == s.serialNumber {
+		return nil, s.err
+	}
+
+	return s.Serializer.Serialize(ctx, event)
+}
+
+func TestEnqueueRoutesDeviceToSamePartition(t *testing.T) {
+	ctx := context.Background()
+
+	collectorService, _ := newTestCollectorService(t, &FakeAzureEventHubsProducerOptions{PartitionsCount: 4}, nil)
+
+	for range 10 {
+		if _, err := collectorService.enqueue(ctx, newTestEvent("SerialNumber", 1, 1), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nonEmptyCount := 0
+
+	for _, partitionQueue := range collectorService.partitionQueues {
+		switch len(partitionQueue.queue) {
+		case 0:
+		case 10:
+			nonEmptyCount++
+		default:
+			t.Errorf("partition %s has %d events, want 0 or 10", partitionQueue.partitionID, len(partitionQueue.queue))
+		}
+	}
+
+	if nonEmptyCount != 1 {
+		t.Errorf("events of the device are in %d partitions, want 1", nonEmptyCount)
+	}
+}
+
+func TestEnqueueWithoutSynchronousAcknowledgement(t *testing.T) {
+	collectorService, _ := newTestCollectorService(t, &FakeAzureEventHubsProducerOptions{PartitionsCount: 1}, nil)
+
+	acknowledgement, err := collectorService.enqueue(context.Background(), newTestEvent("SerialNumber", 1, 1), "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if acknowledgement != nil {
+		t.Error("acknowledgement is not nil without synchronous acknowledgement")
+	}
+}
+
+func TestEnqueueCanceled(t *testing.T) {
+	collectorService, _ := newTestCollectorService(t, &FakeAzureEventHubsProducerOptions{PartitionsCount: 1}, &AzureEventHubsCollectorServiceOptions{PartitionQueueLimit: 1})
+
+	if _, err := collectorService.enqueue(context.Background(), newTestEvent("SerialNumber", 1, 1), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	cancel()
+
+	if _, err := collectorService.enqueue(ctx, newTestEvent("SerialNumber", 1, 1), ""); !errors.Is(err, context.Canceled) {
+		t.Errorf("enqueue to a full queue returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestProduceBatchesEvents(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxBatchBytes  uint64
+		wantBatchCount int
+	}{
+		{name: "single batch", maxBatchBytes: 0, wantBatchCount: 1},
+		{name: "full batches", maxBatchBytes: 1_000, wantBatchCount: 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			collectorService, producer := newTestCollectorService(t, &FakeAzureEventHubsProducerOptions{PartitionsCount: 1, MaxBatchBytes: test.maxBatchBytes}, nil)
+
+			for range 10 {
+				if _, err := collectorService.enqueue(ctx, newTestEvent("SerialNumber", 1, 200), ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			partitionQueue := collectorService.partitionQueues[0]
+
+			if err := produceAll(ctx, collectorService, partitionQueue); err != nil {
+				t.Fatal(err)
+			}
+
+			if eventDatas := producer.EventDatas(partitionQueue.partitionID); len(eventDatas) != 10 {
+				t.Errorf("sent %d events, want 10", len(eventDatas))
+			}
+
+			if batchCount := producer.BatchCount(); batchCount != test.wantBatchCount {
+				t.Errorf("sent %d batches, want %d", batchCount, test.wantBatchCount)
+			}
+		})
+	}
+}
+
+func TestProduceThrottling(t *testing.T) {
+	ctx := context.Background()
+
+	collectorService, producer := newTestCollectorService(t, &FakeAzureEventHubsProducerOptions{PartitionsCount: 1}, &AzureEventHubsCollectorServiceOptions{SynchronousAcknowledgement: true})
+
+	producer.SetThrottlingRate(1)
+
+	acknowledgement, err := collectorService.enqueue(ctx, newTestEvent("SerialNumber", 1, 1), "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	partitionQueue := collectorService.partitionQueues[0]
+
+	if err := produceAll(ctx, collectorService, partitionQueue); !IsThrottlingError(err) {
+		t.Errorf("produce returned %v, want a throttling error", err)
+	}
+
+	if err := <-acknowledgement; !IsThrottlingError(err) {
+		t.Errorf("acknowledgement is %v, want a throttling error", err)
+	}
+
+	if throttlingCount := partitionQueue.throttlingCount.Load(); throttlingCount != 1 {
+		t.Errorf("throttling count is %d, want 1", throttlingCount)
+	}
+
+	if failureCount := producer.FailureCount(); failureCount != 1 {
+		t.Errorf("failure count is %d, want 1", failureCount)
+	}
+}
+
+func TestProduceSplitsOversizedEvent(t *testing.T) {
+	ctx := context.Background()
+
+	collectorService, producer := newTestCollectorService(t, &FakeAzureEventHubsProducerOptions{PartitionsCount: 1, MaxBatchBytes: 1_000}, nil)
+
+	event := newTestEvent("SerialNumber", 20, 100)
+
+	if _, err := collectorService.enqueue(ctx, event, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	partitionQueue := collectorService.partitionQueues[0]
+
+	if err := produceAll(ctx, collectorService, partitionQueue); err != nil {
+		t.Fatal(err)
+	}
+
+	eventDatas := producer.EventDatas(partitionQueue.partitionID)
+
+	if len(eventDatas) < 2 {
+		t.Fatalf("sent %d events, want chunk events", len(eventDatas))
+	}
+
+	correlationID := eventDatas[0].Properties[chunkCorrelationIDProperty]
+
+	for eventDataIndex, eventData := range eventDatas {
+		if eventData.Properties[chunkCorrelationIDProperty] != correlationID {
+			t.Errorf("chunk %d has correlation ID %v, want %v", eventDataIndex, eventData.Properties[chunkCorrelationIDProperty], correlationID)
+		}
+
+		if chunkIndex, ok := eventData.Properties[chunkIndexProperty].(int32); !ok || int(chunkIndex) != eventDataIndex {
+			t.Errorf("chunk %d has chunk index %v, want int32 %d", eventDataIndex, eventData.Properties[chunkIndexProperty], eventDataIndex)
+		}
+
+		if chunkCount, ok := eventData.Properties[chunkCountProperty].(int32); !ok || int(chunkCount) != len(eventDatas) {
+			t.Errorf("chunk %d has chunk count %v, want int32 %d", eventDataIndex, eventData.Properties[chunkCountProperty], len(eventDatas))
+		}
+	}
+
+	parameters := map[string]any{}
+
+	for _, eventData := range eventDatas {
+		chunkEvent := &AzureEventHubsEventModel{}
+
+		if err := json.Unmarshal(eventData.Body, chunkEvent); err != nil {
+			t.Fatal(err)
+		}
+
+		maps.Copy(parameters, chunkEvent.Parameters)
+	}
+
+	if !maps.Equal(parameters, event.Parameters) {
+		t.Error("reassembled parameters differ from the parameters of the event")
+	}
+}
+
+func TestProduceDropsUnsplittableEvent(t *testing.T) {
+	ctx := context.Background()
+
+	collectorService, producer := newTestCollectorService(t, &FakeAzureEventHubsProducerOptions{PartitionsCount: 1, MaxBatchBytes: 1_000}, &AzureEventHubsCollectorServiceOptions{SynchronousAcknowledgement: true})
+
+	oversizedAcknowledgement, err := collectorService.enqueue(ctx, newTestEvent("SerialNumber", 1, 2_000), "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acknowledgement, err := collectorService.enqueue(ctx, newTestEvent("SerialNumber", 1, 1), "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	partitionQueue := collectorService.partitionQueues[0]
+
+	if err := produceAll(ctx, collectorService, partitionQueue); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-oversizedAcknowledgement; !errors.Is(err, azeventhubs.ErrEventDataTooLarge) {
+		t.Errorf("acknowledgement of the oversized event is %v, want %v", err, azeventhubs.ErrEventDataTooLarge)
+	}
+
+	if err := <-acknowledgement; err != nil {
+		t.Errorf("acknowledgement of the following event is %v, want nil", err)
+	}
+
+	if eventDatas := producer.EventDatas(partitionQueue.partitionID); len(eventDatas) != 1 {
+		t.Errorf("sent %d events, want 1", len(eventDatas))
+	}
+}
+
+func TestProduceFailsUnserializableEvent(t *testing.T) {
+	ctx := context.Background()
+
+	errSerialize := errors.New("serialize failed")
+
+	collectorService, producer := newTestCollectorService(t, &FakeAzureEventHubsProducerOptions{PartitionsCount: 1}, &AzureEventHubsCollectorServiceOptions{SynchronousAcknowledgement: true, Serializer: &failingSerializer{Serializer: serializers.NewJSONSerializer(), serialNumber: "Unserializable", err: errSerialize}})
+
+	acknowledgement, err := collectorService.enqueue(ctx, newTestEvent("SerialNumber", 1, 1), "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unserializableAcknowledgement, err := collectorService.enqueue(ctx, newTestEvent("Unserializable", 1, 1), "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	partitionQueue := collectorService.partitionQueues[0]
+
+	if err := produceAll(ctx, collectorService, partitionQueue); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-unserializableAcknowledgement; !errors.Is(err, errSerialize) {
+		t.Errorf("acknowledgement of the unserializable event is %v, want %v", err, errSerialize)
+	}
+
+	if err := <-acknowledgement; err != nil {
+		t.Errorf("acknowledgement of the preceding event is %v, want nil", err)
+	}
+
+	if eventDatas := producer.EventDatas(partitionQueue.partitionID); len(eventDatas) != 1 {
+		t.Errorf("sent %d events, want 1", len(eventDatas))
+	}
+}
+
+func TestCollectSynchronousAcknowledgement(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	defer cancel()
+
+	// The batch interval is longer than the acknowledgement timeout, so the batch must be sent once the queue is drained
+	collectorService, producer := newTestCollectorService(t, &FakeAzureEventHubsProducerOptions{PartitionsCount: 2}, &AzureEventHubsCollectorServiceOptions{SynchronousAcknowledgement: true, AcknowledgementTimeout: 5 * time.Second, PartitionBatchInterval: time.Hour})
+
+	go collectorService.Run(ctx)
+
+	data := &services.DataModel{Reports: []*services.ReportModel{
+		{CollectionTime: time.Now(), Parameters: map[string]any{"Device.DeviceInfo.UpTime": 1}},
+		{CollectionTime: time.Now(), Parameters: map[string]any{"Device.DeviceInfo.UpTime": 2}},
+	}}
+
+	if err := collectorService.Collect(ctx, "OUI", "ProductClass", "SerialNumber", data); err != nil {
+		t.Fatal(err)
+	}
+
+	eventsCount := 0
+
+	for _, partitionQueue := range collectorService.partitionQueues {
+		eventsCount += len(producer.EventDatas(partitionQueue.partitionID))
+	}
+
+	if eventsCount != 2 {
+		t.Errorf("sent %d events, want 2", eventsCount)
+	}
+}
+
+func TestCollectSynchronousAcknowledgementFailure(t *testing.T) {
+	errSend := errors.New("send failed")
+
+	tests := []struct {
+		name    string
+		sendErr error
+		timeout time.Duration
+		wantErr error
+	}{
+		{name: "send error", sendErr: errSend, timeout: 5 * time.Second, wantErr: errSend},
+		{name: "timeout", timeout: 10 * time.Millisecond, wantErr: context.DeadlineExceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+
+			defer cancel()
+
+			collectorService, producer := newTestCollectorService(t, &FakeAzureEventHubsProducerOptions{PartitionsCount: 1, Latency: 100 * time.Millisecond}, &AzureEventHubsCollectorServiceOptions{SynchronousAcknowledgement: true, AcknowledgementTimeout: test.timeout})
+
+			producer.SetSendError(test.sendErr)
+
+			go collectorService.Run(ctx)
+
+			data := &services.DataModel{Reports: []*services.ReportModel{
+				{CollectionTime: time.Now(), Parameters: map[string]any{"Device.DeviceInfo.UpTime": 1}},
+			}}
+
+			err := collectorService.Collect(ctx, "OUI", "ProductClass", "SerialNumber", data)
+
+			if !errors.Is(err, services.ErrUnavailable) || !errors.Is(err, test.wantErr) {
+				t.Errorf("collect returned %v, want %v and %v", err, services.ErrUnavailable, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunSendsEventsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	collectorService, producer := newTestCollectorService(t, &FakeAzureEventHubsProducerOptions{PartitionsCount: 1}, &AzureEventHubsCollectorServiceOptions{PartitionBatchInterval: time.Hour})
+
+	runErrs := make(chan error, 1)
+
+	go func() {
+		runErrs <- collectorService.Run(ctx)
+	}()
+
+	data := &services.DataModel{Reports: []*services.ReportModel{
+		{CollectionTime: time.Now(), Parameters: map[string]any{"Device.DeviceInfo.UpTime": 1}},
+	}}
+
+	if err := collectorService.Collect(ctx, "OUI", "ProductClass", "SerialNumber", data); err != nil {
+		t.Fatal(err)
+	}
+
+	partitionQueue := collectorService.partitionQueues[0]
+
+	// Wait until the event is dequeued into the batch
+	for deadline := time.Now().Add(5 * time.Second); len(partitionQueue.queue) != 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("event is not dequeued")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+
+	var runErr *RunError
+
+	if err := <-runErrs; !errors.As(err, &runErr) || !errors.Is(runErr.PartitionProducerErrs[0], context.Canceled) {
+		t.Errorf("run returned %v, want the partition producer errors %v", err, context.Canceled)
+	}
+
+	if eventDatas := producer.EventDatas(partitionQueue.partitionID); len(eventDatas) != 1 {
+		t.Errorf("sent %d events, want 1", len(eventDatas))
+	}
+}
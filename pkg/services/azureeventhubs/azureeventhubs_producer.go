@@ -0,0 +1,72 @@
+package azureeventhubs
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azeventhubs"
+	"github.com/Azure/go-amqp"
+)
+
+var (
+	ErrInvalidEventDataBatch = errors.New("invalid event data batch")
+	ErrInvalidPartitionID    = errors.New("invalid partition ID")
+)
+
+const (
+	// AMQP error condition returned by Event Hubs when the namespace is throttled
+	serverBusyErrorCondition = "com.microsoft:server-busy"
+)
+
+type AzureEventHubsEventDataBatch interface {
+	AddEventData(eventData *azeventhubs.EventData, options *azeventhubs.AddEventDataOptions) error
+	NumBytes() uint64
+	NumEvents() int32
+}
+
+// AzureEventHubsProducer is the subset of the azeventhubs.ProducerClient API used by the collector.
+type AzureEventHubsProducer interface {
+	GetEventHubProperties(ctx context.Context, options *azeventhubs.GetEventHubPropertiesOptions) (azeventhubs.EventHubProperties, error)
+	NewEventDataBatch(ctx context.Context, options *azeventhubs.EventDataBatchOptions) (AzureEventHubsEventDataBatch, error)
+	SendEventDataBatch(ctx context.Context, eventDataBatch AzureEventHubsEventDataBatch, options *azeventhubs.SendEventDataBatchOptions) error
+	Close(ctx context.Context) error
+}
+
+type producerClientAzureEventHubsProducer struct {
+	producerClient *azeventhubs.ProducerClient
+}
+
+var _ AzureEventHubsProducer = (*producerClientAzureEventHubsProducer)(nil)
+
+func NewAzureEventHubsProducer(producerClient *azeventhubs.ProducerClient) AzureEventHubsProducer {
+	return &producerClientAzureEventHubsProducer{producerClient: producerClient}
+}
+
+func (p *producerClientAzureEventHubsProducer) GetEventHubProperties(ctx context.Context, options *azeventhubs.GetEventHubPropertiesOptions) (azeventhubs.EventHubProperties, error) {
+	return p.producerClient.GetEventHubProperties(ctx, options)
+}
+
+func (p *producerClientAzureEventHubsProducer) NewEventDataBatch(ctx context.Context, options *azeventhubs.EventDataBatchOptions) (AzureEventHubsEventDataBatch, error) {
+	return p.producerClient.NewEventDataBatch(ctx, options)
+}
+
+func (p *producerClientAzureEventHubsProducer) SendEventDataBatch(ctx context.Context, eventDataBatch AzureEventHubsEventDataBatch, options *azeventhubs.SendEventDataBatchOptions) error {
+	producerClientEventDataBatch, ok := eventDataBatch.(*azeventhubs.EventDataBatch)
+
+	if !ok {
+		return ErrInvalidEventDataBatch
+	}
+
+	return p.producerClient.SendEventDataBatch(ctx, producerClientEventDataBatch, options)
+}
+
+func (p *producerClientAzureEventHubsProducer) Close(ctx context.Context) error {
+	return p.producerClient.Close(ctx)
+}
+
+// IsThrottlingError reports whether the error is returned because the Event Hubs namespace is throttled.
+func IsThrottlingError(err error) bool {
+	var amqpErr *amqp.Error
+
+	return errors.As(err, &amqpErr) && amqpErr.Condition == serverBusyErrorCondition
+}
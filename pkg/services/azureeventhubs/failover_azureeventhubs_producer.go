@@ -0,0 +1,430 @@
+package azureeventhubs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azeventhubs"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	primaryNamespace   = "primary"
+	secondaryNamespace = "secondary"
+)
+
+type FailoverAzureEventHubsProducerOptions struct {
+	// FailureThreshold is the number of consecutive send failures, other than throttling, after which the producer fails over to the other namespace.
+	FailureThreshold int
+	// ProbeInterval is the interval of the health probes of the primary namespace while the secondary namespace is active.
+	ProbeInterval time.Duration
+	// FailbackThreshold is the number of consecutive successful health probes after which the producer fails back to the primary namespace.
+	FailbackThreshold int
+	// RetryDelay is the delay before the first retry of a failed send. It doubles with each retry, up to MaxRetryDelay. Defaults to 500 milliseconds.
+	RetryDelay time.Duration
+	// MaxRetryDelay defaults to 10 seconds.
+	MaxRetryDelay time.Duration
+	Logger        *slog.Logger
+}
+
+// FailoverAzureEventHubsProducer sends the batches to the Event Hub in the primary namespace and fails over to the Event Hub in the secondary namespace after repeated send failures. While the secondary namespace is active, Run probes the health of the primary namespace and fails back to it once it recovers.
+//
+// The partition IDs returned by GetEventHubProperties, of the Event Hub that was available first, are mapped by index to the partition IDs of the Event Hub in each namespace, so the Event Hubs do not need to have the same number of partitions.
+type FailoverAzureEventHubsProducer struct {
+	producers             [2]AzureEventHubsProducer
+	options               *FailoverAzureEventHubsProducerOptions
+	logger                *slog.Logger
+	mutex                 sync.RWMutex
+	active                int
+	consecutiveFailures   int
+	referencePartitionIDs []string
+	partitionIDs          [2][]string
+	activeGauge           metric.Int64Gauge
+	failoverCounter       metric.Int64Counter
+}
+
+var _ AzureEventHubsProducer = (*FailoverAzureEventHubsProducer)(nil)
+
+type failoverEventDataBatch struct {
+	producerIndex  int
+	options        *azeventhubs.EventDataBatchOptions
+	eventDataBatch AzureEventHubsEventDataBatch
+	eventDatas     []*azeventhubs.EventData
+}
+
+var _ AzureEventHubsEventDataBatch = (*failoverEventDataBatch)(nil)
+
+func NewFailoverAzureEventHubsProducer(primaryProducer, secondaryProducer AzureEventHubsProducer, options *FailoverAzureEventHubsProducerOptions) (*FailoverAzureEventHubsProducer, error) {
+	meter := otel.Meter(meterName)
+
+	activeGauge, err := meter.Int64Gauge("namespace_active_gauge", metric.WithDescription("Namespace active gauge"), metric.WithUnit("count"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	failoverCounter, err := meter.Int64Counter("namespace_failover_counter", metric.WithDescription("Namespace failover counter"), metric.WithUnit("count"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	logger := slog.Default()
+
+	if options.Logger != nil {
+		logger = options.Logger
+	}
+
+	p := &FailoverAzureEventHubsProducer{
+		producers:       [2]AzureEventHubsProducer{primaryProducer, secondaryProducer},
+		options:         options,
+		logger:          logger,
+		activeGauge:     activeGauge,
+		failoverCounter: failoverCounter,
+	}
+
+	p.recordActive(context.Background(), 0)
+
+	return p, nil
+}
+
+// GetEventHubProperties returns the properties of the Event Hub in the primary namespace, or in the secondary namespace if the primary one is unavailable. The partition IDs returned first become the reference partition IDs.
+func (p *FailoverAzureEventHubsProducer) GetEventHubProperties(ctx context.Context, options *azeventhubs.GetEventHubPropertiesOptions) (azeventhubs.EventHubProperties, error) {
+	eventHubProperties, err := p.getPartitionIDs(ctx, 0)
+
+	if err == nil {
+		p.setReferencePartitionIDs(eventHubProperties.PartitionIDs)
+
+		return eventHubProperties, nil
+	}
+
+	p.logger.Warn("Primary namespace unavailable", "error", err)
+
+	eventHubProperties, err = p.getPartitionIDs(ctx, 1)
+
+	if err != nil {
+		return azeventhubs.EventHubProperties{}, err
+	}
+
+	p.setReferencePartitionIDs(eventHubProperties.PartitionIDs)
+
+	p.failover(ctx, 0)
+
+	return eventHubProperties, nil
+}
+
+func (p *FailoverAzureEventHubsProducer) NewEventDataBatch(ctx context.Context, options *azeventhubs.EventDataBatchOptions) (AzureEventHubsEventDataBatch, error) {
+	p.mutex.RLock()
+	active := p.active
+	p.mutex.RUnlock()
+
+	return p.newEventDataBatch(ctx, active, options)
+}
+
+// SendEventDataBatch sends the batch to the active namespace. If the send fails, it is retried and the producer fails over once the failure threshold is reached. Throttling errors do not count toward the failure threshold. The batch is rebuilt for the active namespace if it was created for the other one.
+func (p *FailoverAzureEventHubsProducer) SendEventDataBatch(ctx context.Context, eventDataBatch AzureEventHubsEventDataBatch, options *azeventhubs.SendEventDataBatchOptions) error {
+	batch, ok := eventDataBatch.(*failoverEventDataBatch)
+
+	if !ok {
+		return ErrInvalidEventDataBatch
+	}
+
+	failureThreshold := 3
+
+	if p.options.FailureThreshold > 0 {
+		failureThreshold = p.options.FailureThreshold
+	}
+
+	retryDelay := 500 * time.Millisecond
+	maxRetryDelay := 10 * time.Second
+
+	if p.options.RetryDelay > 0 {
+		retryDelay = p.options.RetryDelay
+	}
+
+	if p.options.MaxRetryDelay > 0 {
+		maxRetryDelay = p.options.MaxRetryDelay
+	}
+
+	var err error
+
+	// Each namespace gets up to failure threshold attempts
+	for attempt := range 2 * failureThreshold {
+		p.mutex.RLock()
+		active := p.active
+		p.mutex.RUnlock()
+
+		// The retries of the same namespace back off, while the first attempt after a failover is immediate
+		if attempt != 0 && batch.producerIndex == active {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(retryDelay):
+			}
+
+			retryDelay = min(2*retryDelay, maxRetryDelay)
+		}
+
+		if batch.producerIndex != active {
+			rebuiltBatch, err := p.rebuildEventDataBatch(ctx, active, batch)
+
+			if err != nil {
+				return err
+			}
+
+			batch = rebuiltBatch
+		}
+
+		if err = p.producers[active].SendEventDataBatch(ctx, batch.eventDataBatch, options); err == nil {
+			p.mutex.Lock()
+			if p.active == active {
+				p.consecutiveFailures = 0
+			}
+			p.mutex.Unlock()
+
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return err
+		}
+
+		p.logger.Warn("Send failed", "namespace", namespaceName(active), "error", err)
+
+		// Throttling does not tell that the namespace is unavailable, so it is only retried with the backoff
+		if IsThrottlingError(err) {
+			continue
+		}
+
+		p.mutex.Lock()
+		failover := false
+		if p.active == active {
+			p.consecutiveFailures++
+
+			failover = p.consecutiveFailures >= failureThreshold
+		}
+		p.mutex.Unlock()
+
+		if failover {
+			p.failover(ctx, active)
+		}
+	}
+
+	return err
+}
+
+func (p *FailoverAzureEventHubsProducer) Close(ctx context.Context) error {
+	return errors.Join(p.producers[0].Close(ctx), p.producers[1].Close(ctx))
+}
+
+// Run probes the health of the primary namespace while the secondary namespace is active and fails back to the primary namespace once it is healthy.
+func (p *FailoverAzureEventHubsProducer) Run(ctx context.Context) error {
+	probeInterval := 30 * time.Second
+	failbackThreshold := 3
+
+	if p.options.ProbeInterval > 0 {
+		probeInterval = p.options.ProbeInterval
+	}
+
+	if p.options.FailbackThreshold > 0 {
+		failbackThreshold = p.options.FailbackThreshold
+	}
+
+	ticker := time.NewTicker(probeInterval)
+
+	defer ticker.Stop()
+
+	consecutiveSuccesses := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			p.mutex.RLock()
+			active := p.active
+			p.mutex.RUnlock()
+
+			if active == 0 {
+				consecutiveSuccesses = 0
+
+				continue
+			}
+
+			probeCtx, cancel := context.WithTimeout(ctx, probeInterval)
+
+			_, err := p.getPartitionIDs(probeCtx, 0)
+
+			cancel()
+
+			if err != nil {
+				consecutiveSuccesses = 0
+
+				continue
+			}
+
+			consecutiveSuccesses++
+
+			if consecutiveSuccesses >= failbackThreshold {
+				consecutiveSuccesses = 0
+
+				p.failover(ctx, 1)
+			}
+		}
+	}
+}
+
+// failover switches from the namespace to the other one, unless this has already happened.
+func (p *FailoverAzureEventHubsProducer) failover(ctx context.Context, from int) {
+	p.mutex.Lock()
+
+	if p.active != from {
+		p.mutex.Unlock()
+
+		return
+	}
+
+	to := 1 - from
+
+	p.active = to
+	p.consecutiveFailures = 0
+
+	p.mutex.Unlock()
+
+	p.logger.Warn("Namespace failover", "from", namespaceName(from), "to", namespaceName(to))
+
+	p.failoverCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("from", namespaceName(from)), attribute.String("to", namespaceName(to))))
+	p.recordActive(ctx, to)
+}
+
+func (p *FailoverAzureEventHubsProducer) recordActive(ctx context.Context, active int) {
+	for producerIndex := range p.producers {
+		value := int64(0)
+
+		if producerIndex == active {
+			value = 1
+		}
+
+		p.activeGauge.Record(ctx, value, metric.WithAttributes(attribute.String("namespace", namespaceName(producerIndex))))
+	}
+}
+
+func (p *FailoverAzureEventHubsProducer) getPartitionIDs(ctx context.Context, producerIndex int) (azeventhubs.EventHubProperties, error) {
+	eventHubProperties, err := p.producers[producerIndex].GetEventHubProperties(ctx, nil)
+
+	if err != nil {
+		return azeventhubs.EventHubProperties{}, err
+	}
+
+	p.mutex.Lock()
+	if p.partitionIDs[producerIndex] == nil {
+		p.partitionIDs[producerIndex] = eventHubProperties.PartitionIDs
+	}
+	p.mutex.Unlock()
+
+	return eventHubProperties, nil
+}
+
+func (p *FailoverAzureEventHubsProducer) setReferencePartitionIDs(partitionIDs []string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.referencePartitionIDs == nil {
+		p.referencePartitionIDs = partitionIDs
+	}
+}
+
+// mapPartitionID maps the reference partition ID to the partition ID of the Event Hub of the producer. The partition IDs of an Event Hub that was unavailable at startup are retrieved once it is available.
+func (p *FailoverAzureEventHubsProducer) mapPartitionID(ctx context.Context, producerIndex int, partitionID string) (string, error) {
+	p.mutex.RLock()
+	referencePartitionIDs, partitionIDs := p.referencePartitionIDs, p.partitionIDs[producerIndex]
+	p.mutex.RUnlock()
+
+	if partitionIDs == nil {
+		eventHubProperties, err := p.getPartitionIDs(ctx, producerIndex)
+
+		if err != nil {
+			return "", err
+		}
+
+		partitionIDs = eventHubProperties.PartitionIDs
+	}
+
+	partitionIndex := slices.Index(referencePartitionIDs, partitionID)
+
+	if partitionIndex < 0 || len(partitionIDs) == 0 {
+		return "", ErrInvalidPartitionID
+	}
+
+	return partitionIDs[partitionIndex%len(partitionIDs)], nil
+}
+
+func (p *FailoverAzureEventHubsProducer) newEventDataBatch(ctx context.Context, producerIndex int, options *azeventhubs.EventDataBatchOptions) (*failoverEventDataBatch, error) {
+	producerOptions := options
+
+	if options != nil && options.PartitionID != nil {
+		partitionID, err := p.mapPartitionID(ctx, producerIndex, *options.PartitionID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		producerOptions = &azeventhubs.EventDataBatchOptions{MaxBytes: options.MaxBytes, PartitionID: &partitionID}
+	}
+
+	eventDataBatch, err := p.producers[producerIndex].NewEventDataBatch(ctx, producerOptions)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &failoverEventDataBatch{producerIndex: producerIndex, options: options, eventDataBatch: eventDataBatch}, nil
+}
+
+func (p *FailoverAzureEventHubsProducer) rebuildEventDataBatch(ctx context.Context, producerIndex int, batch *failoverEventDataBatch) (*failoverEventDataBatch, error) {
+	rebuiltBatch, err := p.newEventDataBatch(ctx, producerIndex, batch.options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, eventData := range batch.eventDatas {
+		if err := rebuiltBatch.AddEventData(eventData, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return rebuiltBatch, nil
+}
+
+func (b *failoverEventDataBatch) AddEventData(eventData *azeventhubs.EventData, options *azeventhubs.AddEventDataOptions) error {
+	if err := b.eventDataBatch.AddEventData(eventData, options); err != nil {
+		return err
+	}
+
+	b.eventDatas = append(b.eventDatas, eventData)
+
+	return nil
+}
+
+func (b *failoverEventDataBatch) NumBytes() uint64 {
+	return b.eventDataBatch.NumBytes()
+}
+
+func (b *failoverEventDataBatch) NumEvents() int32 {
+	return b.eventDataBatch.NumEvents()
+}
+
+func namespaceName(producerIndex int) string {
+	if producerIndex == 0 {
+		return primaryNamespace
+	}
+
+	return secondaryNamespace
+}
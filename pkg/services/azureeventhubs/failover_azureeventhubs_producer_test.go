@@ -0,0 +1,137 @@
+package azureeventhubs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azeventhubs"
+)
+
+func newTestFailoverProducer(t *testing.T, options *FailoverAzureEventHubsProducerOptions) (*FailoverAzureEventHubsProducer, *FakeAzureEventHubsProducer, *FakeAzureEventHubsProducer) {
+	t.Helper()
+
+	primaryProducer := NewFakeAzureEventHubsProducer(&FakeAzureEventHubsProducerOptions{PartitionsCount: 2})
+	secondaryProducer := NewFakeAzureEventHubsProducer(&FakeAzureEventHubsProducerOptions{PartitionsCount: 2})
+
+	failoverProducer, err := NewFailoverAzureEventHubsProducer(primaryProducer, secondaryProducer, options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := failoverProducer.GetEventHubProperties(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return failoverProducer, primaryProducer, secondaryProducer
+}
+
+// sendTestEventData sends a batch with a single event to the partition.
+func sendTestEventData(ctx context.Context, producer AzureEventHubsProducer, partitionID string) error {
+	eventDataBatch, err := producer.NewEventDataBatch(ctx, &azeventhubs.EventDataBatchOptions{PartitionID: &partitionID})
+
+	if err != nil {
+		return err
+	}
+
+	if err := eventDataBatch.AddEventData(&azeventhubs.EventData{Body: []byte("Body")}, nil); err != nil {
+		return err
+	}
+
+	return producer.SendEventDataBatch(ctx, eventDataBatch, nil)
+}
+
+func activeNamespace(producer *FailoverAzureEventHubsProducer) int {
+	producer.mutex.RLock()
+	defer producer.mutex.RUnlock()
+
+	return producer.active
+}
+
+func TestFailoverAfterFailureThreshold(t *testing.T) {
+	ctx := context.Background()
+
+	failoverProducer, primaryProducer, secondaryProducer := newTestFailoverProducer(t, &FailoverAzureEventHubsProducerOptions{FailureThreshold: 2, RetryDelay: time.Millisecond})
+
+	primaryProducer.SetSendError(errors.New("send failed"))
+
+	if err := sendTestEventData(ctx, failoverProducer, "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if active := activeNamespace(failoverProducer); active != 1 {
+		t.Errorf("active namespace is %s, want %s", namespaceName(active), secondaryNamespace)
+	}
+
+	if failureCount := primaryProducer.FailureCount(); failureCount != 2 {
+		t.Errorf("primary failure count is %d, want 2", failureCount)
+	}
+
+	if eventDatas := secondaryProducer.EventDatas("1"); len(eventDatas) != 1 {
+		t.Errorf("sent %d events to the secondary partition, want 1", len(eventDatas))
+	}
+}
+
+func TestFailoverIgnoresThrottling(t *testing.T) {
+	ctx := context.Background()
+
+	failoverProducer, primaryProducer, secondaryProducer := newTestFailoverProducer(t, &FailoverAzureEventHubsProducerOptions{FailureThreshold: 2, RetryDelay: time.Millisecond})
+
+	primaryProducer.SetThrottlingRate(1)
+
+	if err := sendTestEventData(ctx, failoverProducer, "0"); !IsThrottlingError(err) {
+		t.Errorf("send returned %v, want a throttling error", err)
+	}
+
+	if active := activeNamespace(failoverProducer); active != 0 {
+		t.Errorf("active namespace is %s, want %s", namespaceName(active), primaryNamespace)
+	}
+
+	if failureCount := primaryProducer.FailureCount(); failureCount != 4 {
+		t.Errorf("primary failure count is %d, want 4", failureCount)
+	}
+
+	if batchCount := secondaryProducer.BatchCount(); batchCount != 0 {
+		t.Errorf("sent %d batches to the secondary namespace, want 0", batchCount)
+	}
+}
+
+func TestFailback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	defer cancel()
+
+	failoverProducer, primaryProducer, _ := newTestFailoverProducer(t, &FailoverAzureEventHubsProducerOptions{FailureThreshold: 1, ProbeInterval: time.Millisecond, FailbackThreshold: 2, RetryDelay: time.Millisecond})
+
+	primaryProducer.SetSendError(errors.New("send failed"))
+
+	if err := sendTestEventData(ctx, failoverProducer, "0"); err != nil {
+		t.Fatal(err)
+	}
+
+	if active := activeNamespace(failoverProducer); active != 1 {
+		t.Fatalf("active namespace is %s, want %s", namespaceName(active), secondaryNamespace)
+	}
+
+	primaryProducer.SetSendError(nil)
+
+	go failoverProducer.Run(ctx)
+
+	for deadline := time.Now().Add(5 * time.Second); activeNamespace(failoverProducer) != 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("producer does not fail back")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := sendTestEventData(ctx, failoverProducer, "0"); err != nil {
+		t.Fatal(err)
+	}
+
+	if eventDatas := primaryProducer.EventDatas("0"); len(eventDatas) != 1 {
+		t.Errorf("sent %d events to the primary partition, want 1", len(eventDatas))
+	}
+}
@@ -0,0 +1,231 @@
+package azureeventhubs
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azeventhubs"
+	"github.com/Azure/go-amqp"
+)
+
+const (
+	// Approximate AMQP encoding overhead of a batch and of each event in it
+	fakeEventDataBatchOverheadBytes = 64
+	fakeEventDataOverheadBytes      = 32
+)
+
+type FakeAzureEventHubsProducerOptions struct {
+	EventHubName    string
+	PartitionsCount int
+	MaxBatchBytes   uint64
+	// Latency is the time each send takes.
+	Latency time.Duration
+	// ThrottlingRate is the probability in the range [0, 1] that a send fails with a server busy error.
+	ThrottlingRate float64
+}
+
+// FakeAzureEventHubsProducer is an in-process AzureEventHubsProducer for tests. It simulates the partitions, the batch size limit, the send latency and the throttling of an Event Hub and keeps the sent events in memory, so the collector can be tested without an Azure Event Hubs namespace.
+type FakeAzureEventHubsProducer struct {
+	options        *FakeAzureEventHubsProducerOptions
+	partitionIDs   []string
+	mutex          sync.Mutex
+	partitions     map[string][]*azeventhubs.EventData
+	throttlingRate float64
+	batchCount     int
+	failureCount   int
+	sendErr        error
+	closed         bool
+}
+
+var _ AzureEventHubsProducer = (*FakeAzureEventHubsProducer)(nil)
+
+type fakeEventDataBatch struct {
+	partitionID string
+	maxBytes    uint64
+	numBytes    uint64
+	eventDatas  []*azeventhubs.EventData
+}
+
+var _ AzureEventHubsEventDataBatch = (*fakeEventDataBatch)(nil)
+
+func NewFakeAzureEventHubsProducer(options *FakeAzureEventHubsProducerOptions) *FakeAzureEventHubsProducer {
+	partitionsCount := 4
+
+	if options.PartitionsCount > 0 {
+		partitionsCount = options.PartitionsCount
+	}
+
+	partitionIDs := make([]string, 0, partitionsCount)
+	partitions := make(map[string][]*azeventhubs.EventData, partitionsCount)
+
+	for partitionIndex := range partitionsCount {
+		partitionID := strconv.Itoa(partitionIndex)
+
+		partitionIDs = append(partitionIDs, partitionID)
+		partitions[partitionID] = []*azeventhubs.EventData{}
+	}
+
+	return &FakeAzureEventHubsProducer{options: options, partitionIDs: partitionIDs, partitions: partitions, throttlingRate: options.ThrottlingRate}
+}
+
+func (p *FakeAzureEventHubsProducer) GetEventHubProperties(ctx context.Context, options *azeventhubs.GetEventHubPropertiesOptions) (azeventhubs.EventHubProperties, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return azeventhubs.EventHubProperties{}, &azeventhubs.Error{Code: azeventhubs.ErrorCodeConnectionLost}
+	}
+
+	return azeventhubs.EventHubProperties{Name: p.options.EventHubName, PartitionIDs: p.partitionIDs}, nil
+}
+
+func (p *FakeAzureEventHubsProducer) NewEventDataBatch(ctx context.Context, options *azeventhubs.EventDataBatchOptions) (AzureEventHubsEventDataBatch, error) {
+	maxBatchBytes := uint64(1_048_576)
+
+	if p.options.MaxBatchBytes > 0 {
+		maxBatchBytes = p.options.MaxBatchBytes
+	}
+
+	partitionID := p.partitionIDs[0]
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if options != nil {
+		if options.MaxBytes > 0 {
+			maxBatchBytes = min(maxBatchBytes, options.MaxBytes)
+		}
+
+		if options.PartitionID != nil {
+			if _, ok := p.partitions[*options.PartitionID]; !ok {
+				return nil, ErrInvalidPartitionID
+			}
+
+			partitionID = *options.PartitionID
+		}
+	}
+
+	return &fakeEventDataBatch{partitionID: partitionID, maxBytes: maxBatchBytes, numBytes: fakeEventDataBatchOverheadBytes}, nil
+}
+
+func (p *FakeAzureEventHubsProducer) SendEventDataBatch(ctx context.Context, eventDataBatch AzureEventHubsEventDataBatch, options *azeventhubs.SendEventDataBatchOptions) error {
+	fakeEventDataBatch, ok := eventDataBatch.(*fakeEventDataBatch)
+
+	if !ok {
+		return ErrInvalidEventDataBatch
+	}
+
+	if p.options.Latency > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.options.Latency):
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return &azeventhubs.Error{Code: azeventhubs.ErrorCodeConnectionLost}
+	}
+
+	if p.sendErr != nil {
+		p.failureCount++
+
+		return p.sendErr
+	}
+
+	if p.throttlingRate > 0 && rand.Float64() < p.throttlingRate {
+		p.failureCount++
+
+		return &amqp.Error{Condition: serverBusyErrorCondition, Description: "The request was terminated because the namespace is being throttled."}
+	}
+
+	p.partitions[fakeEventDataBatch.partitionID] = append(p.partitions[fakeEventDataBatch.partitionID], fakeEventDataBatch.eventDatas...)
+	p.batchCount++
+
+	return nil
+}
+
+func (p *FakeAzureEventHubsProducer) Close(ctx context.Context) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.closed = true
+
+	return nil
+}
+
+// SetSendError makes the following sends fail with the error, until it is set to nil.
+func (p *FakeAzureEventHubsProducer) SetSendError(err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.sendErr = err
+}
+
+// SetThrottlingRate changes the probability that a send fails with a server busy error.
+func (p *FakeAzureEventHubsProducer) SetThrottlingRate(throttlingRate float64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.throttlingRate = throttlingRate
+}
+
+// EventDatas returns the events sent to the partition.
+func (p *FakeAzureEventHubsProducer) EventDatas(partitionID string) []*azeventhubs.EventData {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return append([]*azeventhubs.EventData(nil), p.partitions[partitionID]...)
+}
+
+// BatchCount returns the number of sent batches.
+func (p *FakeAzureEventHubsProducer) BatchCount() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.batchCount
+}
+
+// FailureCount returns the number of failed sends.
+func (p *FakeAzureEventHubsProducer) FailureCount() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.failureCount
+}
+
+func (b *fakeEventDataBatch) AddEventData(eventData *azeventhubs.EventData, options *azeventhubs.AddEventDataOptions) error {
+	numBytes := uint64(fakeEventDataOverheadBytes + len(eventData.Body))
+
+	if eventData.ContentType != nil {
+		numBytes += uint64(len(*eventData.ContentType))
+	}
+
+	for key, value := range eventData.Properties {
+		numBytes += uint64(len(key) + len(fmt.Sprint(value)))
+	}
+
+	if b.numBytes+numBytes > b.maxBytes {
+		return azeventhubs.ErrEventDataTooLarge
+	}
+
+	b.numBytes += numBytes
+	b.eventDatas = append(b.eventDatas, eventData)
+
+	return nil
+}
+
+func (b *fakeEventDataBatch) NumBytes() uint64 {
+	return b.numBytes
+}
+
+func (b *fakeEventDataBatch) NumEvents() int32 {
+	return int32(len(b.eventDatas))
+}
@@ -0,0 +1,223 @@
+package azureeventhubs
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	// Directions of the scaling decisions
+	scalingDirectionUp   = "up"
+	scalingDirectionDown = "down"
+	// Directions of the scaling decisions
+
+	// Reasons of the scaling decisions
+	scalingReasonMinCount   = "min_count"
+	scalingReasonQueueDepth = "queue_depth"
+	scalingReasonThrottling = "throttling"
+	scalingReasonFailure    = "failure"
+	// Reasons of the scaling decisions
+
+	// Failed partition producers are restarted indefinitely, so only their most recent errors are kept
+	maxPartitionProducersErrs = 16
+)
+
+type PartitionProducersScalingOptions struct {
+	MinCount int
+	MaxCount int
+	// Interval is the interval of the scaling decisions.
+	Interval time.Duration
+	// ScaleUpQueueRatio is the partition queue fill ratio at or above which a partition producer is added.
+	ScaleUpQueueRatio float64
+	// ScaleDownQueueRatio is the partition queue fill ratio at or below which a partition producer is removed. Nil defaults to 0.1 and a negative ratio disables the scale down by queue depth.
+	ScaleDownQueueRatio *float64
+	// MaxSendLatency is the average batch send latency above which no partition producer is added, because Event Hubs is already saturated. Zero disables the check.
+	MaxSendLatency time.Duration
+	Logger         *slog.Logger
+}
+
+// partitionProducersScaler scales the partition producers of a partition queue between the configured bounds, based on the depth of the queue, the batch send latency and the throttling responses of Event Hubs.
+type partitionProducersScaler struct {
+	service        *AzureEventHubsCollectorService
+	partitionQueue *partitionQueue
+	options        *PartitionProducersScalingOptions
+	logger         *slog.Logger
+	stops          []chan struct{}
+	stopped        chan chan struct{}
+	group          sync.WaitGroup
+	errsMutex      sync.Mutex
+	errs           []error
+}
+
+func newPartitionProducersScaler(service *AzureEventHubsCollectorService, partitionQueue *partitionQueue, options *PartitionProducersScalingOptions) *partitionProducersScaler {
+	scalingOptions := *options
+
+	if scalingOptions.MinCount <= 0 {
+		scalingOptions.MinCount = 1
+	}
+
+	if scalingOptions.MaxCount < scalingOptions.MinCount {
+		scalingOptions.MaxCount = max(scalingOptions.MinCount, 8)
+	}
+
+	if scalingOptions.Interval <= 0 {
+		scalingOptions.Interval = 10 * time.Second
+	}
+
+	if scalingOptions.ScaleUpQueueRatio <= 0 {
+		scalingOptions.ScaleUpQueueRatio = 0.5
+	}
+
+	if scalingOptions.ScaleDownQueueRatio == nil {
+		scaleDownQueueRatio := 0.1
+
+		scalingOptions.ScaleDownQueueRatio = &scaleDownQueueRatio
+	}
+
+	logger := slog.Default()
+
+	if scalingOptions.Logger != nil {
+		logger = scalingOptions.Logger
+	}
+
+	return &partitionProducersScaler{
+		service:        service,
+		partitionQueue: partitionQueue,
+		options:        &scalingOptions,
+		logger:         logger,
+		stopped:        make(chan chan struct{}),
+	}
+}
+
+// run starts the initial partition producers and scales them until the context is done. It returns the most recent errors of the partition producers.
+func (c *partitionProducersScaler) run(ctx context.Context, partitionProducersCount int) []error {
+	for range min(max(partitionProducersCount, c.options.MinCount), c.options.MaxCount) {
+		c.start(ctx)
+	}
+
+	c.record(ctx)
+
+	ticker := time.NewTicker(c.options.Interval)
+
+	defer ticker.Stop()
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+
+		case stop := <-c.stopped:
+			// A partition producer failed, unless it was scaled down. It is restarted by the next scaling decision.
+			if index := slices.Index(c.stops, stop); index >= 0 {
+				c.stops = slices.Delete(c.stops, index, index+1)
+
+				c.decide(ctx, scalingDirectionDown, scalingReasonFailure)
+			}
+
+		case <-ticker.C:
+			c.scale(ctx)
+		}
+	}
+
+	c.group.Wait()
+
+	return c.errs
+}
+
+func (c *partitionProducersScaler) scale(ctx context.Context) {
+	queueRatio := float64(len(c.partitionQueue.queue)) / float64(cap(c.partitionQueue.queue))
+
+	sendCount := c.partitionQueue.sendCount.Swap(0)
+	sendDuration := time.Duration(c.partitionQueue.sendDuration.Swap(0))
+	throttlingCount := c.partitionQueue.throttlingCount.Swap(0)
+
+	var sendLatency time.Duration
+
+	if sendCount != 0 {
+		sendLatency = sendDuration / time.Duration(sendCount)
+	}
+
+	count := len(c.stops)
+
+	switch {
+	case count < c.options.MinCount:
+		for range c.options.MinCount - count {
+			c.start(ctx)
+		}
+
+		c.decide(ctx, scalingDirectionUp, scalingReasonMinCount)
+
+	case throttlingCount != 0 && count > c.options.MinCount:
+		// More partition producers would only make the throttling worse
+		c.stop()
+
+		c.decide(ctx, scalingDirectionDown, scalingReasonThrottling)
+
+	case throttlingCount == 0 && queueRatio >= c.options.ScaleUpQueueRatio && count < c.options.MaxCount && (c.options.MaxSendLatency == 0 || sendLatency <= c.options.MaxSendLatency):
+		c.start(ctx)
+
+		c.decide(ctx, scalingDirectionUp, scalingReasonQueueDepth)
+
+	case queueRatio <= *c.options.ScaleDownQueueRatio && count > c.options.MinCount:
+		c.stop()
+
+		c.decide(ctx, scalingDirectionDown, scalingReasonQueueDepth)
+	}
+}
+
+func (c *partitionProducersScaler) start(ctx context.Context) {
+	stop := make(chan struct{})
+
+	c.stops = append(c.stops, stop)
+
+	c.group.Add(1)
+
+	go func() {
+		defer c.group.Done()
+
+		if err := c.service.produce(ctx, c.partitionQueue, stop); err != nil {
+			if ctx.Err() == nil {
+				c.logger.Error("Partition producer failed", "partition", c.partitionQueue.partitionID, "error", err)
+			}
+
+			c.errsMutex.Lock()
+			c.errs = append(c.errs, err)
+
+			if len(c.errs) > maxPartitionProducersErrs {
+				c.errs = slices.Delete(c.errs, 0, len(c.errs)-maxPartitionProducersErrs)
+			}
+			c.errsMutex.Unlock()
+		}
+
+		select {
+		case <-ctx.Done():
+		case c.stopped <- stop:
+		}
+	}()
+}
+
+// stop stops the most recently started partition producer, which sends its batch before it returns.
+func (c *partitionProducersScaler) stop() {
+	stop := c.stops[len(c.stops)-1]
+
+	c.stops = c.stops[:len(c.stops)-1]
+
+	close(stop)
+}
+
+func (c *partitionProducersScaler) decide(ctx context.Context, direction, reason string) {
+	c.service.scalingCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("partition", c.partitionQueue.partitionID), attribute.String("direction", direction), attribute.String("reason", reason)))
+
+	c.record(ctx)
+}
+
+func (c *partitionProducersScaler) record(ctx context.Context) {
+	c.service.producersGauge.Record(ctx, int64(len(c.stops)), metric.WithAttributes(attribute.String("partition", c.partitionQueue.partitionID)))
+}
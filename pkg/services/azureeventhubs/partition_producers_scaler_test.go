@@ -0,0 +1,95 @@
+package azureeventhubs
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScaleOnQueueDepth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	collectorService, _ := newTestCollectorService(t, &FakeAzureEventHubsProducerOptions{PartitionsCount: 1}, &AzureEventHubsCollectorServiceOptions{PartitionQueueLimit: 4, PartitionBatchInterval: time.Hour})
+
+	partitionQueue := collectorService.partitionQueues[0]
+
+	scaler := newPartitionProducersScaler(collectorService, partitionQueue, &PartitionProducersScalingOptions{MinCount: 1, MaxCount: 2})
+
+	defer func() {
+		cancel()
+
+		scaler.group.Wait()
+	}()
+
+	// The minimum partition producer does not consume the queue, so the queue depth is under the control of the test
+	scaler.stops = append(scaler.stops, make(chan struct{}))
+
+	for range cap(partitionQueue.queue) {
+		if _, err := collectorService.enqueue(ctx, newTestEvent("SerialNumber", 1, 1), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scaler.scale(ctx)
+
+	if count := len(scaler.stops); count != 2 {
+		t.Fatalf("scaled up to %d partition producers, want 2", count)
+	}
+
+	// The added partition producer drains the queue
+	for deadline := time.Now().Add(5 * time.Second); len(partitionQueue.queue) != 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("queue is not drained")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	scaler.scale(ctx)
+
+	if count := len(scaler.stops); count != 1 {
+		t.Errorf("scaled down to %d partition producers, want 1", count)
+	}
+}
+
+func TestScaleWithinBounds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	collectorService, _ := newTestCollectorService(t, &FakeAzureEventHubsProducerOptions{PartitionsCount: 1}, &AzureEventHubsCollectorServiceOptions{PartitionQueueLimit: 4})
+
+	partitionQueue := collectorService.partitionQueues[0]
+
+	scaler := newPartitionProducersScaler(collectorService, partitionQueue, &PartitionProducersScalingOptions{MinCount: 1, MaxCount: 1})
+
+	defer func() {
+		cancel()
+
+		scaler.group.Wait()
+	}()
+
+	scaler.stops = append(scaler.stops, make(chan struct{}))
+
+	for range cap(partitionQueue.queue) {
+		if _, err := collectorService.enqueue(ctx, newTestEvent("SerialNumber", 1, 1), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The queue is full, but the partition producers are at the maximum
+	scaler.scale(ctx)
+
+	if count := len(scaler.stops); count != 1 {
+		t.Errorf("scaled to %d partition producers, want 1", count)
+	}
+
+	// The queue is drained, but the partition producers are at the minimum
+	for range cap(partitionQueue.queue) {
+		<-partitionQueue.queue
+	}
+
+	scaler.scale(ctx)
+
+	if count := len(scaler.stops); count != 1 {
+		t.Errorf("scaled to %d partition producers, want 1", count)
+	}
+}
@@ -3,11 +3,22 @@ package services
 import (
 	"context"
 	"errors"
+	"net/url"
 	"time"
 )
 
+const (
+	// TR-069 and TR-369 report formats
+	ReportFormat_ParameterPerRow    = "ParameterPerRow"
+	ReportFormat_ParameterPerColumn = "ParameterPerColumn"
+	ReportFormat_NameValuePair      = "NameValuePair"
+	ReportFormat_ObjectHierarchy    = "ObjectHierarchy"
+	// TR-069 and TR-369 report formats
+)
+
 var (
 	ErrBackpressure = errors.New("backpressure")
+	ErrUnavailable  = errors.New("unavailable")
 )
 
 type ReportModel struct {
@@ -38,6 +49,29 @@ type JSONBulkDataModel struct {
 	NameValuePair *NameValuePairModel
 }
 
+// EventModel has the same fields as the event models of the collector backends, so each of them can be converted to it and handled by the shared serializers.
+type EventModel struct {
+	CollectionTime time.Time      `json:"CollectionTime"`
+	OUI            string         `json:"OUI"`
+	ProductClass   string         `json:"ProductClass"`
+	SerialNumber   string         `json:"SerialNumber"`
+	Parameters     map[string]any `json:"Parameters"`
+}
+
+type queryParametersKey struct{}
+
+// WithQueryParameters returns a copy of the context with the query parameters of the request of the device, so the collector services can use them, for example as metric attributes.
+func WithQueryParameters(ctx context.Context, queryParameters url.Values) context.Context {
+	return context.WithValue(ctx, queryParametersKey{}, queryParameters)
+}
+
+// QueryParameters returns the query parameters of the request of the device, if any.
+func QueryParameters(ctx context.Context) url.Values {
+	queryParameters, _ := ctx.Value(queryParametersKey{}).(url.Values)
+
+	return queryParameters
+}
+
 type CollectorService interface {
 	Collect(ctx context.Context, oui, productClass, serialNumber string, data *DataModel) error
 	CollectCSV(ctx context.Context, oui, productClass, serialNumber string, bulkData *CSVBulkDataModel) error
@@ -0,0 +1,192 @@
+package dapr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type daprBulkEntry struct {
+	message         *daprMessage
+	acknowledgement chan error
+}
+
+// daprBulkPublisher buffers the messages of the requests and publishes them with the bulk publish API, one call per topic, once the buffer is full or on each interval. Each message is acknowledged with the result of its publish.
+type daprBulkPublisher struct {
+	service  *DaprCollectorService
+	size     int
+	interval time.Duration
+	mutex    sync.Mutex
+	entries  []*daprBulkEntry
+	full     chan struct{}
+}
+
+func newDaprBulkPublisher(service *DaprCollectorService, size int, interval time.Duration) *daprBulkPublisher {
+	if size <= 0 {
+		size = 100
+	}
+
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	return &daprBulkPublisher{service: service, size: size, interval: interval, full: make(chan struct{}, 1)}
+}
+
+// publish buffers the messages and waits until they are published.
+func (p *daprBulkPublisher) publish(ctx context.Context, messages []*daprMessage) error {
+	entries := make([]*daprBulkEntry, 0, len(messages))
+
+	for _, message := range messages {
+		entries = append(entries, &daprBulkEntry{message: message, acknowledgement: make(chan error, 1)})
+	}
+
+	p.mutex.Lock()
+	p.entries = append(p.entries, entries...)
+	full := len(p.entries) >= p.size
+	p.mutex.Unlock()
+
+	if full {
+		select {
+		case p.full <- struct{}{}:
+		default:
+		}
+	}
+
+	failedCount := 0
+
+	var err error
+
+	for _, entry := range entries {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case entryErr := <-entry.acknowledgement:
+			if entryErr != nil {
+				failedCount++
+
+				err = entryErr
+			}
+		}
+	}
+
+	if failedCount != 0 {
+		return fmt.Errorf("%w: %d events: %w", ErrBulkPublish, failedCount, err)
+	}
+
+	return nil
+}
+
+// run flushes the buffer until the context is done, then flushes the remaining messages.
+func (p *daprBulkPublisher) run(ctx context.Context) error {
+	ticker := time.NewTicker(p.interval)
+
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			p.flush(context.WithoutCancel(ctx))
+
+			return ctx.Err()
+		case <-p.full:
+			p.flush(ctx)
+		case <-ticker.C:
+			p.flush(ctx)
+		}
+	}
+}
+
+func (p *daprBulkPublisher) flush(ctx context.Context) {
+	p.mutex.Lock()
+	entries := p.entries
+	p.entries = nil
+	p.mutex.Unlock()
+
+	topicNames := []string{}
+	topicEntries := map[string][]*daprBulkEntry{}
+
+	for _, entry := range entries {
+		if _, ok := topicEntries[entry.message.topicName]; !ok {
+			topicNames = append(topicNames, entry.message.topicName)
+		}
+
+		topicEntries[entry.message.topicName] = append(topicEntries[entry.message.topicName], entry)
+	}
+
+	for _, topicName := range topicNames {
+		p.publishTopic(ctx, topicName, topicEntries[topicName])
+	}
+}
+
+// publishTopic publishes the entries of the topic. The failed entries are published again with a backoff, up to the bulk publish attempts.
+func (p *daprBulkPublisher) publishTopic(ctx context.Context, topicName string, entries []*daprBulkEntry) {
+	retryDelay := bulkPublishRetryDelay
+
+	var err error
+
+	for attempt := range bulkPublishAttempts {
+		if attempt != 0 {
+			select {
+			case <-ctx.Done():
+				acknowledge(entries, ctx.Err())
+
+				return
+			case <-time.After(retryDelay):
+			}
+
+			retryDelay *= 2
+		}
+
+		messages := make([]*daprMessage, 0, len(entries))
+
+		for _, entry := range entries {
+			messages = append(messages, entry.message)
+		}
+
+		var failedMessages []*daprMessage
+
+		failedMessages, err = p.service.tryPublishBulk(ctx, topicName, messages)
+
+		failed := make(map[*daprMessage]bool, len(failedMessages))
+
+		for _, failedMessage := range failedMessages {
+			failed[failedMessage] = true
+		}
+
+		failedEntries := make([]*daprBulkEntry, 0, len(failedMessages))
+
+		for _, entry := range entries {
+			if failed[entry.message] {
+				failedEntries = append(failedEntries, entry)
+
+				continue
+			}
+
+			var stateErr error
+
+			if p.service.options.StateStoreName != "" {
+				stateErr = p.service.saveDeviceState(ctx, entry.message.event, entry.message.deviceName)
+			}
+
+			entry.acknowledgement <- stateErr
+		}
+
+		if entries = failedEntries; len(entries) == 0 {
+			return
+		}
+	}
+
+	if err == nil {
+		err = ErrBulkPublish
+	}
+
+	acknowledge(entries, err)
+}
+
+func acknowledge(entries []*daprBulkEntry, err error) {
+	for _, entry := range entries {
+		entry.acknowledgement <- err
+	}
+}
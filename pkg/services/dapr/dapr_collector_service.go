@@ -2,14 +2,68 @@ package dapr
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
 	"time"
 
 	daprclient "github.com/dapr/go-sdk/client"
 
+	"github.com/zdrgeo/bulk-data-collector/pkg/cloudevents"
+	"github.com/zdrgeo/bulk-data-collector/pkg/serializers"
 	"github.com/zdrgeo/bulk-data-collector/pkg/services"
 )
 
+const (
+	// Dapr pub/sub metadata (https://docs.dapr.io/reference/api/pubsub_api/#metadata)
+	metadataRawPayload   = "rawPayload"
+	metadataTTLInSeconds = "ttlInSeconds"
+	metadataPartitionKey = "partitionKey"
+	// Dapr pub/sub metadata
+
+	// Dapr CloudEvent envelope metadata
+	metadataCloudEventID      = "cloudevent.id"
+	metadataCloudEventSource  = "cloudevent.source"
+	metadataCloudEventType    = "cloudevent.type"
+	metadataCloudEventSubject = "cloudevent.subject"
+	metadataCloudEventTime    = "cloudevent.time"
+	// Dapr CloudEvent envelope metadata
+
+	// Dapr binding metadata
+	metadataContentType = "contentType"
+	// Dapr binding metadata
+
+	// Prefix of the CloudEvent attributes in the binding metadata, as in the binary content mode of the CloudEvents HTTP binding
+	metadataCloudEventAttributePrefix = "ce-"
+)
+
+const (
+	// Modes of the collector service
+	Mode_PubSub     = "PubSub"
+	Mode_BulkPubSub = "BulkPubSub"
+	Mode_Binding    = "Binding"
+	Mode_Actor      = "Actor"
+	// Modes of the collector service
+
+	defaultBindingOperation = "create"
+
+	// Attempts to publish the failed entries of a bulk publish
+	bulkPublishAttempts = 3
+	// Delay before the first retry of the failed entries of a bulk publish, which doubles with each retry
+	bulkPublishRetryDelay = 100 * time.Millisecond
+)
+
+var (
+	ErrInvalidMode         = errors.New("invalid mode")
+	ErrBulkPublish         = errors.New("bulk publish failed")
+	ErrBindingNameRequired = errors.New("binding name required")
+)
+
 type DaprEventModel struct {
 	CollectionTime time.Time      `json:"CollectionTime"`
 	OUI            string         `json:"OUI"`
@@ -18,23 +72,118 @@ type DaprEventModel struct {
 	Parameters     map[string]any `json:"Parameters"`
 }
 
+// DaprMetadataModel is the data of the metadata templates.
+type DaprMetadataModel struct {
+	PubSubName     string
+	TopicName      string
+	OUI            string
+	ProductClass   string
+	SerialNumber   string
+	DeviceName     string
+	ReportFormat   string
+	CollectionTime time.Time
+}
+
 type DaprCollectorServiceOptions struct {
 	PubSubName string
 	TopicName  string
+	Serializer serializers.Serializer
+	CloudEvent *cloudevents.CloudEventOptions
+	// ContentType overrides the content type of the serializer.
+	ContentType string
+	// RawPayload publishes the events without the CloudEvent envelope of Dapr.
+	RawPayload bool
+	// TTL is the time to live of the events. Zero means the events do not expire.
+	TTL time.Duration
+	// PartitionKey routes the events of each device to the same partition, by using the device name as partition key.
+	PartitionKey bool
+	// Metadata is the metadata of the events, whose values are text/templates over DaprMetadataModel, for example {{.SerialNumber}}.
+	Metadata map[string]string
+	// StateStoreName is the Dapr state store the last known state of each device is upserted into, alongside publishing. Empty disables the device state.
+	StateStoreName string
+	// Mode defaults to Mode_PubSub.
+	Mode string
+	// BulkSize is the number of buffered events that triggers a bulk publish in the Mode_BulkPubSub mode. Defaults to 100.
+	BulkSize int
+	// BulkInterval is the interval of the bulk publishes of the buffered events in the Mode_BulkPubSub mode. Defaults to 1 second.
+	BulkInterval time.Duration
+	// BindingName is the Dapr output binding of the Mode_Binding mode.
+	BindingName string
+	// BindingOperation defaults to create.
+	BindingOperation string
+	// ActorType is the type of the device actors of the Mode_Actor mode. Defaults to DefaultDeviceActorType.
+	ActorType string
+}
+
+type daprMessage struct {
+	event       *DaprEventModel
+	deviceName  string
+	topicName   string
+	data        []byte
+	contentType string
+	metadata    map[string]string
 }
 
 type DaprCollectorService struct {
-	daprClient daprclient.Client
-	options    *DaprCollectorServiceOptions
+	daprClient        daprclient.Client
+	options           *DaprCollectorServiceOptions
+	serializer        serializers.Serializer
+	metadataTemplates map[string]*template.Template
+	bulkPublisher     *daprBulkPublisher
 }
 
 var _ services.CollectorService = (*DaprCollectorService)(nil)
 
-func NewDaprCollectorService(daprClient daprclient.Client, option *DaprCollectorServiceOptions) *DaprCollectorService {
-	return &DaprCollectorService{daprClient: daprClient, options: option}
+func NewDaprCollectorService(daprClient daprclient.Client, option *DaprCollectorServiceOptions) (*DaprCollectorService, error) {
+	var serializer serializers.Serializer = serializers.NewJSONSerializer()
+
+	if option.Serializer != nil {
+		serializer = option.Serializer
+	}
+
+	switch option.Mode {
+	case "", Mode_PubSub, Mode_BulkPubSub, Mode_Binding, Mode_Actor:
+	default:
+		return nil, ErrInvalidMode
+	}
+
+	if option.Mode == Mode_Binding && option.BindingName == "" {
+		return nil, ErrBindingNameRequired
+	}
+
+	metadataTemplates := make(map[string]*template.Template, len(option.Metadata))
+
+	for name, value := range option.Metadata {
+		metadataTemplate, err := template.New(name).Option("missingkey=error").Parse(value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		metadataTemplates[name] = metadataTemplate
+	}
+
+	s := &DaprCollectorService{daprClient: daprClient, options: option, serializer: serializer, metadataTemplates: metadataTemplates}
+
+	if option.Mode == Mode_BulkPubSub {
+		s.bulkPublisher = newDaprBulkPublisher(s, option.BulkSize, option.BulkInterval)
+	}
+
+	return s, nil
+}
+
+// Run publishes the buffered events in the Mode_BulkPubSub mode until the context is done. In the other modes it returns immediately.
+func (s *DaprCollectorService) Run(ctx context.Context) error {
+	if s.bulkPublisher == nil {
+		return nil
+	}
+
+	return s.bulkPublisher.run(ctx)
 }
 
 func (s *DaprCollectorService) Collect(ctx context.Context, oui, productClass, serialNumber string, data *services.DataModel) error {
+	events := make([]*DaprEventModel, 0, len(data.Reports))
+
 	for _, report := range data.Reports {
 		event := &DaprEventModel{
 			CollectionTime: report.CollectionTime,
@@ -48,15 +197,10 @@ func (s *DaprCollectorService) Collect(ctx context.Context, oui, productClass, s
 			event.Parameters[key] = value
 		}
 
-		deviceName := fmt.Sprintf("%s-%s-%s", oui, productClass, serialNumber)
-		topicName := fmt.Sprintf("%s/device/%s/event", s.options.TopicName, deviceName)
-
-		if err := s.daprClient.PublishEvent(ctx, s.options.PubSubName, topicName, event); err != nil {
-			return err
-		}
+		events = append(events, event)
 	}
 
-	return nil
+	return s.publish(ctx, events, "")
 }
 
 func (s *DaprCollectorService) CollectCSV(ctx context.Context, oui, productClass, serialNumber string, bulkData *services.CSVBulkDataModel) error {
@@ -66,6 +210,8 @@ func (s *DaprCollectorService) CollectCSV(ctx context.Context, oui, productClass
 		reports[parameterPerRow.ReportTimestamp] = append(reports[parameterPerRow.ReportTimestamp], parameterPerRow)
 	}
 
+	events := make([]*DaprEventModel, 0, len(reports))
+
 	for reportTimestamp, report := range reports {
 		event := &DaprEventModel{
 			CollectionTime: reportTimestamp,
@@ -85,18 +231,15 @@ func (s *DaprCollectorService) CollectCSV(ctx context.Context, oui, productClass
 			event.Parameters[parameterPerRow.ParameterName] = value
 		}
 
-		deviceName := fmt.Sprintf("%s-%s-%s", oui, productClass, serialNumber)
-		topicName := fmt.Sprintf("%s/device/%s/event", s.options.TopicName, deviceName)
-
-		if err := s.daprClient.PublishEvent(ctx, s.options.PubSubName, topicName, event); err != nil {
-			return err
-		}
+		events = append(events, event)
 	}
 
-	return nil
+	return s.publish(ctx, events, services.ReportFormat_ParameterPerRow)
 }
 
 func (s *DaprCollectorService) CollectJSON(ctx context.Context, oui, productClass, serialNumber string, bulkData *services.JSONBulkDataModel) error {
+	events := []*DaprEventModel{}
+
 	if bulkData.NameValuePair != nil {
 		for _, report := range bulkData.NameValuePair.Report {
 			event := &DaprEventModel{
@@ -111,14 +254,270 @@ func (s *DaprCollectorService) CollectJSON(ctx context.Context, oui, productClas
 				event.Parameters[key] = value
 			}
 
-			deviceName := fmt.Sprintf("%s-%s-%s", oui, productClass, serialNumber)
-			topicName := fmt.Sprintf("%s/device/%s/event", s.options.TopicName, deviceName)
+			events = append(events, event)
+		}
+	}
 
-			if err := s.daprClient.PublishEvent(ctx, s.options.PubSubName, topicName, event); err != nil {
-				return err
+	return s.publish(ctx, events, services.ReportFormat_NameValuePair)
+}
+
+// publish publishes the events of a request one by one, in bulk with the events of other requests or to the output binding, depending on the mode.
+func (s *DaprCollectorService) publish(ctx context.Context, events []*DaprEventModel, reportFormat string) error {
+	if s.options.Mode == Mode_Actor {
+		return s.invokeActors(ctx, events, reportFormat)
+	}
+
+	messages := make([]*daprMessage, 0, len(events))
+
+	for _, event := range events {
+		message, err := s.newMessage(ctx, event, reportFormat)
+
+		if err != nil {
+			return err
+		}
+
+		messages = append(messages, message)
+	}
+
+	if s.bulkPublisher != nil {
+		return s.bulkPublisher.publish(ctx, messages)
+	}
+
+	for _, message := range messages {
+		if err := s.publishMessage(ctx, message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *DaprCollectorService) publishMessage(ctx context.Context, message *daprMessage) error {
+	if s.options.Mode == Mode_Binding {
+		bindingOperation := defaultBindingOperation
+
+		if s.options.BindingOperation != "" {
+			bindingOperation = s.options.BindingOperation
+		}
+
+		invokeBindingRequest := &daprclient.InvokeBindingRequest{
+			Name:      s.options.BindingName,
+			Operation: bindingOperation,
+			Data:      message.data,
+			Metadata:  message.metadata,
+		}
+
+		if err := s.daprClient.InvokeOutputBinding(ctx, invokeBindingRequest); err != nil {
+			return err
+		}
+	} else {
+		publishEventOptions := []daprclient.PublishEventOption{daprclient.PublishEventWithContentType(message.contentType)}
+
+		if len(message.metadata) != 0 {
+			publishEventOptions = append(publishEventOptions, daprclient.PublishEventWithMetadata(message.metadata))
+		}
+
+		if err := s.daprClient.PublishEvent(ctx, s.options.PubSubName, message.topicName, message.data, publishEventOptions...); err != nil {
+			return err
+		}
+	}
+
+	if s.options.StateStoreName != "" {
+		return s.saveDeviceState(ctx, message.event, message.deviceName)
+	}
+
+	return nil
+}
+
+// tryPublishBulk publishes the messages in bulk and returns the failed ones.
+func (s *DaprCollectorService) tryPublishBulk(ctx context.Context, topicName string, messages []*daprMessage) ([]*daprMessage, error) {
+	events := make([]any, 0, len(messages))
+
+	for index, message := range messages {
+		events = append(events, daprclient.PublishEventsEvent{
+			EntryID:     strconv.Itoa(index),
+			Data:        message.data,
+			ContentType: message.contentType,
+			Metadata:    message.metadata,
+		})
+	}
+
+	publishEventsOptions := []daprclient.PublishEventsOption{}
+
+	if s.options.RawPayload {
+		publishEventsOptions = append(publishEventsOptions, daprclient.PublishEventsWithRawPayload())
+	}
+
+	publishEventsResponse := s.daprClient.PublishEvents(ctx, s.options.PubSubName, topicName, events, publishEventsOptions...)
+
+	failed := make(map[int]bool, len(publishEventsResponse.FailedEvents))
+
+	for _, failedEvent := range publishEventsResponse.FailedEvents {
+		if publishEventsEvent, ok := failedEvent.(daprclient.PublishEventsEvent); ok {
+			if index, err := strconv.Atoi(publishEventsEvent.EntryID); err == nil {
+				failed[index] = true
 			}
 		}
 	}
 
+	failedMessages := make([]*daprMessage, 0, len(failed))
+
+	for index, message := range messages {
+		if failed[index] {
+			failedMessages = append(failedMessages, message)
+		}
+	}
+
+	return failedMessages, publishEventsResponse.Error
+}
+
+// invokeActors sends the events to the actors of their devices, oldest first, so the actors see the reports in order.
+func (s *DaprCollectorService) invokeActors(ctx context.Context, events []*DaprEventModel, reportFormat string) error {
+	actorType := DefaultDeviceActorType
+
+	if s.options.ActorType != "" {
+		actorType = s.options.ActorType
+	}
+
+	slices.SortFunc(events, func(a, b *DaprEventModel) int {
+		return a.CollectionTime.Compare(b.CollectionTime)
+	})
+
+	for _, event := range events {
+		report := &DaprDeviceReportModel{
+			CollectionTime: event.CollectionTime,
+			OUI:            event.OUI,
+			ProductClass:   event.ProductClass,
+			SerialNumber:   event.SerialNumber,
+			ReportFormat:   reportFormat,
+			Parameters:     event.Parameters,
+		}
+
+		data, err := json.Marshal(report)
+
+		if err != nil {
+			return err
+		}
+
+		invokeActorRequest := &daprclient.InvokeActorRequest{
+			ActorType: actorType,
+			ActorID:   fmt.Sprintf("%s-%s-%s", event.OUI, event.ProductClass, event.SerialNumber),
+			Method:    DeviceActorMethod_Report,
+			Data:      data,
+		}
+
+		if _, err := s.daprClient.InvokeActor(ctx, invokeActorRequest); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// newMessage serializes the event and prepares its topic, content type and metadata.
+func (s *DaprCollectorService) newMessage(ctx context.Context, event *DaprEventModel, reportFormat string) (*daprMessage, error) {
+	deviceName := fmt.Sprintf("%s-%s-%s", event.OUI, event.ProductClass, event.SerialNumber)
+	topicName := fmt.Sprintf("%s/device/%s/event", s.options.TopicName, deviceName)
+
+	data, err := s.serializer.Serialize(ctx, (*services.EventModel)(event))
+
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := s.serializer.ContentType()
+
+	if s.options.ContentType != "" {
+		contentType = s.options.ContentType
+	}
+
+	metadataModel := &DaprMetadataModel{
+		PubSubName:     s.options.PubSubName,
+		TopicName:      topicName,
+		OUI:            event.OUI,
+		ProductClass:   event.ProductClass,
+		SerialNumber:   event.SerialNumber,
+		DeviceName:     deviceName,
+		ReportFormat:   reportFormat,
+		CollectionTime: event.CollectionTime,
+	}
+
+	metadata, err := s.newMetadata(metadataModel)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if s.options.CloudEvent != nil {
+		cloudEvent := cloudevents.NewCloudEvent(s.options.CloudEvent.Source, cloudevents.NewType(reportFormat), deviceName, event.CollectionTime, contentType, data)
+
+		if s.options.CloudEvent.Mode == cloudevents.ModeBinary && s.options.Mode == Mode_Binding {
+			// The bindings have no CloudEvent envelope, so the attributes go with the metadata, which for example the HTTP binding sends as headers
+			for name, value := range cloudEvent.Attributes() {
+				metadata[metadataCloudEventAttributePrefix+name] = value
+			}
+		} else if s.options.CloudEvent.Mode == cloudevents.ModeBinary {
+			// Dapr wraps the data in its own CloudEvent and overrides the attributes of the envelope from the metadata
+			attributes := cloudEvent.Attributes()
+
+			metadata[metadataCloudEventID] = cloudEvent.ID
+			metadata[metadataCloudEventSource] = cloudEvent.Source
+			metadata[metadataCloudEventType] = cloudEvent.Type
+
+			if subject, ok := attributes[cloudevents.Attribute_Subject]; ok {
+				metadata[metadataCloudEventSubject] = subject
+			}
+
+			if eventTime, ok := attributes[cloudevents.Attribute_Time]; ok {
+				metadata[metadataCloudEventTime] = eventTime
+			}
+		} else {
+			if data, err = json.Marshal(cloudEvent); err != nil {
+				return nil, err
+			}
+
+			contentType = cloudevents.StructuredContentType
+		}
+	}
+
+	if s.options.Mode == Mode_Binding {
+		// The bindings get no content type of their own
+		metadata[metadataContentType] = contentType
+	}
+
+	return &daprMessage{event: event, deviceName: deviceName, topicName: topicName, data: data, contentType: contentType, metadata: metadata}, nil
+}
+
+// newMetadata renders the metadata templates and, unless in the Mode_Binding mode, adds the pub/sub metadata of the publish options.
+func (s *DaprCollectorService) newMetadata(metadataModel *DaprMetadataModel) (map[string]string, error) {
+	metadata := make(map[string]string, len(s.metadataTemplates)+3)
+
+	for name, metadataTemplate := range s.metadataTemplates {
+		builder := &strings.Builder{}
+
+		if err := metadataTemplate.Execute(builder, metadataModel); err != nil {
+			return nil, err
+		}
+
+		metadata[name] = builder.String()
+	}
+
+	if s.options.Mode == Mode_Binding {
+		return metadata, nil
+	}
+
+	if s.options.RawPayload {
+		metadata[metadataRawPayload] = strconv.FormatBool(true)
+	}
+
+	if s.options.TTL > 0 {
+		// Rounded up, so a TTL below a second does not become zero, which means no expiration
+		metadata[metadataTTLInSeconds] = strconv.FormatInt(int64(max(math.Ceil(s.options.TTL.Seconds()), 1)), 10)
+	}
+
+	if s.options.PartitionKey {
+		metadata[metadataPartitionKey] = metadataModel.DeviceName
+	}
+
+	return metadata, nil
+}
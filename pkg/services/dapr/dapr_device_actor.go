@@ -0,0 +1,331 @@
+package dapr
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/dapr/go-sdk/actor"
+	daprclient "github.com/dapr/go-sdk/client"
+)
+
+const (
+	DefaultDeviceActorType = "Device"
+
+	// Methods of the device actor
+	DeviceActorMethod_Report = "Report"
+	DeviceActorMethod_Get    = "Get"
+	// Methods of the device actor
+
+	deviceActorStateName            = "device"
+	deviceActorMissedReportReminder = "MissedReport"
+)
+
+// DaprDeviceReportModel is the report the collector sends to the actor of the device.
+type DaprDeviceReportModel struct {
+	CollectionTime time.Time      `json:"CollectionTime"`
+	OUI            string         `json:"OUI"`
+	ProductClass   string         `json:"ProductClass"`
+	SerialNumber   string         `json:"SerialNumber"`
+	ReportFormat   string         `json:"ReportFormat"`
+	Parameters     map[string]any `json:"Parameters"`
+}
+
+// DaprStatisticsModel is the rolling statistics of a series of values. Mean is an exponential moving average.
+type DaprStatisticsModel struct {
+	Count int64   `json:"Count"`
+	Last  float64 `json:"Last"`
+	Min   float64 `json:"Min"`
+	Max   float64 `json:"Max"`
+	Mean  float64 `json:"Mean"`
+}
+
+type DaprParameterActorStateModel struct {
+	CollectionTime time.Time            `json:"CollectionTime"`
+	Value          any                  `json:"Value"`
+	Statistics     *DaprStatisticsModel `json:"Statistics,omitempty"`
+}
+
+// DaprDeviceActorStateModel is the state of the actor of a device. ReportInterval is in seconds. ConsecutiveMissedReportCount is the number of the reporting intervals missed since the last report.
+type DaprDeviceActorStateModel struct {
+	OUI                          string                                   `json:"OUI"`
+	ProductClass                 string                                   `json:"ProductClass"`
+	SerialNumber                 string                                   `json:"SerialNumber"`
+	FirstReportTime              time.Time                                `json:"FirstReportTime"`
+	LastReportTime               time.Time                                `json:"LastReportTime"`
+	ReportCount                  int64                                    `json:"ReportCount"`
+	ReportInterval               *DaprStatisticsModel                     `json:"ReportInterval,omitempty"`
+	Missed                       bool                                     `json:"Missed"`
+	MissedReportCount            int64                                    `json:"MissedReportCount"`
+	ConsecutiveMissedReportCount int64                                    `json:"ConsecutiveMissedReportCount"`
+	Parameters                   map[string]*DaprParameterActorStateModel `json:"Parameters"`
+}
+
+type DaprDeviceActorOptions struct {
+	// ActorType defaults to DefaultDeviceActorType.
+	ActorType string
+	// ReportingInterval is the expected interval between the reports of a device. If not set, it is learned from the reports.
+	ReportingInterval time.Duration
+	// MissedReportTolerance is the number of reporting intervals after which the report of a device is missed. Defaults to 1.5.
+	MissedReportTolerance float64
+	// SmoothingFactor of the moving averages, in the range (0, 1]. Defaults to 0.2.
+	SmoothingFactor float64
+	// MaxMissedReports is the number of consecutive missed reporting intervals after which the missed report reminder is unregistered, until the device reports again. Defaults to 10.
+	MaxMissedReports int
+	Logger           *slog.Logger
+}
+
+// DaprDeviceActor is the Dapr virtual actor of a device, whose ID is the device name. It keeps the last known parameters and the rolling statistics of the device and a reminder that fires when the device misses its reporting interval.
+type DaprDeviceActor struct {
+	actor.ServerImplBaseCtx
+	daprClient daprclient.Client
+	options    *DaprDeviceActorOptions
+	logger     *slog.Logger
+}
+
+// NewDaprDeviceActorFactory returns the factory to register with the Dapr service that hosts the actors.
+func NewDaprDeviceActorFactory(daprClient daprclient.Client, options *DaprDeviceActorOptions) actor.FactoryContext {
+	logger := options.Logger
+
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return func() actor.ServerContext {
+		return &DaprDeviceActor{daprClient: daprClient, options: options, logger: logger}
+	}
+}
+
+func (a *DaprDeviceActor) Type() string {
+	if a.options.ActorType != "" {
+		return a.options.ActorType
+	}
+
+	return DefaultDeviceActorType
+}
+
+// Report merges the report into the state of the device and reschedules the missed report reminder.
+func (a *DaprDeviceActor) Report(ctx context.Context, report *DaprDeviceReportModel) error {
+	deviceState, err := a.getState(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	deviceState.OUI = report.OUI
+	deviceState.ProductClass = report.ProductClass
+	deviceState.SerialNumber = report.SerialNumber
+	deviceState.ReportCount++
+	deviceState.Missed = false
+	deviceState.ConsecutiveMissedReportCount = 0
+
+	smoothingFactor := a.smoothingFactor()
+
+	if deviceState.FirstReportTime.IsZero() || report.CollectionTime.Before(deviceState.FirstReportTime) {
+		deviceState.FirstReportTime = report.CollectionTime
+	}
+
+	if report.CollectionTime.After(deviceState.LastReportTime) {
+		if !deviceState.LastReportTime.IsZero() {
+			if deviceState.ReportInterval == nil {
+				deviceState.ReportInterval = &DaprStatisticsModel{}
+			}
+
+			deviceState.ReportInterval.update(report.CollectionTime.Sub(deviceState.LastReportTime).Seconds(), smoothingFactor)
+		}
+
+		deviceState.LastReportTime = report.CollectionTime
+	}
+
+	for parameterName, value := range report.Parameters {
+		parameterState, ok := deviceState.Parameters[parameterName]
+
+		if !ok {
+			parameterState = &DaprParameterActorStateModel{}
+
+			deviceState.Parameters[parameterName] = parameterState
+		}
+
+		if parameterState.CollectionTime.After(report.CollectionTime) {
+			continue
+		}
+
+		parameterState.CollectionTime = report.CollectionTime
+		parameterState.Value = value
+
+		if number, ok := toFloat64(value); ok {
+			if parameterState.Statistics == nil {
+				parameterState.Statistics = &DaprStatisticsModel{}
+			}
+
+			parameterState.Statistics.update(number, smoothingFactor)
+		}
+	}
+
+	if err := a.GetStateManager().Set(ctx, deviceActorStateName, deviceState); err != nil {
+		return err
+	}
+
+	return a.registerMissedReportReminder(ctx, deviceState)
+}
+
+// Get returns the state of the device, so other Dapr applications can use the actors as device registry.
+func (a *DaprDeviceActor) Get(ctx context.Context) (*DaprDeviceActorStateModel, error) {
+	return a.getState(ctx)
+}
+
+// ReminderCall is called by Dapr when the device missed its reporting interval. The reminder fires again each interval, until the device reports or misses the maximum number of reporting intervals.
+func (a *DaprDeviceActor) ReminderCall(reminderName string, state []byte, dueTime string, period string) {
+	if reminderName != deviceActorMissedReportReminder {
+		return
+	}
+
+	ctx := context.Background()
+
+	deviceState, err := a.getState(ctx)
+
+	if err != nil {
+		a.logger.Error("Get device state failed", "device", a.ID(), "error", err)
+
+		return
+	}
+
+	deviceState.Missed = true
+	deviceState.MissedReportCount++
+	deviceState.ConsecutiveMissedReportCount++
+
+	a.logger.Warn("Device missed its report", "device", a.ID(), "lastReportTime", deviceState.LastReportTime, "missedReportCount", deviceState.MissedReportCount)
+
+	if err := a.GetStateManager().Set(ctx, deviceActorStateName, deviceState); err != nil {
+		a.logger.Error("Set device state failed", "device", a.ID(), "error", err)
+
+		return
+	}
+
+	if err := a.SaveState(ctx); err != nil {
+		a.logger.Error("Save device state failed", "device", a.ID(), "error", err)
+	}
+
+	maxMissedReports := 10
+
+	if a.options.MaxMissedReports > 0 {
+		maxMissedReports = a.options.MaxMissedReports
+	}
+
+	// The device is probably gone, so the reminder stops keeping its actor busy. The next report registers the reminder again.
+	if deviceState.ConsecutiveMissedReportCount >= int64(maxMissedReports) {
+		unregisterActorReminderRequest := &daprclient.UnregisterActorReminderRequest{
+			ActorType: a.Type(),
+			ActorID:   a.ID(),
+			Name:      deviceActorMissedReportReminder,
+		}
+
+		if err := a.daprClient.UnregisterActorReminder(ctx, unregisterActorReminderRequest); err != nil {
+			a.logger.Error("Unregister reminder failed", "device", a.ID(), "error", err)
+		}
+	}
+}
+
+func (a *DaprDeviceActor) getState(ctx context.Context) (*DaprDeviceActorStateModel, error) {
+	deviceState := &DaprDeviceActorStateModel{}
+
+	ok, err := a.GetStateManager().Contains(ctx, deviceActorStateName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		if err := a.GetStateManager().Get(ctx, deviceActorStateName, deviceState); err != nil {
+			return nil, err
+		}
+	}
+
+	if deviceState.Parameters == nil {
+		deviceState.Parameters = map[string]*DaprParameterActorStateModel{}
+	}
+
+	return deviceState, nil
+}
+
+// registerMissedReportReminder replaces the missed report reminder of the actor. Until the reporting interval is known, the reminder is not registered.
+func (a *DaprDeviceActor) registerMissedReportReminder(ctx context.Context, deviceState *DaprDeviceActorStateModel) error {
+	reportingInterval := a.options.ReportingInterval
+
+	if reportingInterval <= 0 {
+		if deviceState.ReportInterval == nil || deviceState.ReportInterval.Mean <= 0 {
+			return nil
+		}
+
+		reportingInterval = time.Duration(deviceState.ReportInterval.Mean * float64(time.Second))
+	}
+
+	missedReportTolerance := 1.5
+
+	if a.options.MissedReportTolerance > 0 {
+		missedReportTolerance = a.options.MissedReportTolerance
+	}
+
+	registerActorReminderRequest := &daprclient.RegisterActorReminderRequest{
+		ActorType: a.Type(),
+		ActorID:   a.ID(),
+		Name:      deviceActorMissedReportReminder,
+		// Dapr reminders have a resolution of a second, and a period of "0s" would not repeat the reminder
+		DueTime: max(time.Duration(float64(reportingInterval)*missedReportTolerance).Round(time.Second), time.Second).String(),
+		Period:  max(reportingInterval.Round(time.Second), time.Second).String(),
+	}
+
+	if err := a.daprClient.RegisterActorReminder(ctx, registerActorReminderRequest); err != nil {
+		return fmt.Errorf("register reminder: %w", err)
+	}
+
+	return nil
+}
+
+func (a *DaprDeviceActor) smoothingFactor() float64 {
+	if a.options.SmoothingFactor > 0 && a.options.SmoothingFactor <= 1 {
+		return a.options.SmoothingFactor
+	}
+
+	return 0.2
+}
+
+func (s *DaprStatisticsModel) update(value float64, smoothingFactor float64) {
+	if s.Count == 0 {
+		s.Min = value
+		s.Max = value
+		s.Mean = value
+	} else {
+		s.Min = min(s.Min, value)
+		s.Max = max(s.Max, value)
+		s.Mean += smoothingFactor * (value - s.Mean)
+	}
+
+	s.Count++
+	s.Last = value
+}
+
+// toFloat64 converts the numeric value, or the string of a number, of a parameter for the statistics.
+func toFloat64(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case string:
+		float64Value, err := strconv.ParseFloat(v, 64)
+
+		return float64Value, err == nil && !math.IsNaN(float64Value) && !math.IsInf(float64Value, 0)
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,88 @@
+package dapr
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	daprclient "github.com/dapr/go-sdk/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Attempts to save the device state before an ETag mismatch is returned as error
+	deviceStateSaveAttempts = 5
+)
+
+type DaprParameterStateModel struct {
+	CollectionTime time.Time `json:"CollectionTime"`
+	Value          any       `json:"Value"`
+}
+
+// DaprDeviceStateModel is the last known state of a device, stored under the device name as key.
+type DaprDeviceStateModel struct {
+	OUI            string                              `json:"OUI"`
+	ProductClass   string                              `json:"ProductClass"`
+	SerialNumber   string                              `json:"SerialNumber"`
+	CollectionTime time.Time                           `json:"CollectionTime"`
+	Parameters     map[string]*DaprParameterStateModel `json:"Parameters"`
+}
+
+// saveDeviceState merges the parameters of the event into the state of the device. A parameter keeps its value if the event is older than the value. The state is saved with first write concurrency, so the merge is retried with the current state when another collector instance saved the state in the meantime.
+func (s *DaprCollectorService) saveDeviceState(ctx context.Context, event *DaprEventModel, deviceName string) error {
+	var err error
+
+	for range deviceStateSaveAttempts {
+		if err = s.trySaveDeviceState(ctx, event, deviceName); status.Code(err) != codes.Aborted {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (s *DaprCollectorService) trySaveDeviceState(ctx context.Context, event *DaprEventModel, deviceName string) error {
+	stateItem, err := s.daprClient.GetState(ctx, s.options.StateStoreName, deviceName, nil)
+
+	if err != nil {
+		return err
+	}
+
+	deviceState := &DaprDeviceStateModel{
+		OUI:          event.OUI,
+		ProductClass: event.ProductClass,
+		SerialNumber: event.SerialNumber,
+		Parameters:   map[string]*DaprParameterStateModel{},
+	}
+
+	if len(stateItem.Value) != 0 {
+		if err := json.Unmarshal(stateItem.Value, deviceState); err != nil {
+			return err
+		}
+
+		if deviceState.Parameters == nil {
+			deviceState.Parameters = map[string]*DaprParameterStateModel{}
+		}
+	}
+
+	if event.CollectionTime.After(deviceState.CollectionTime) {
+		deviceState.CollectionTime = event.CollectionTime
+	}
+
+	for parameterName, value := range event.Parameters {
+		if parameterState, ok := deviceState.Parameters[parameterName]; ok && parameterState.CollectionTime.After(event.CollectionTime) {
+			continue
+		}
+
+		deviceState.Parameters[parameterName] = &DaprParameterStateModel{CollectionTime: event.CollectionTime, Value: value}
+	}
+
+	data, err := json.Marshal(deviceState)
+
+	if err != nil {
+		return err
+	}
+
+	return s.daprClient.SaveStateWithETag(ctx, s.options.StateStoreName, deviceName, data, stateItem.Etag, nil, daprclient.WithConcurrency(daprclient.StateConcurrencyFirstWrite))
+}
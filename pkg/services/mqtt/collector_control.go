@@ -0,0 +1,111 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+const (
+	// Commands of the collector control
+	Command_EnableParameterFilter  = "EnableParameterFilter"
+	Command_DisableParameterFilter = "DisableParameterFilter"
+	Command_PauseDevice            = "PauseDevice"
+	Command_ResumeDevice           = "ResumeDevice"
+	Command_SetLogLevel            = "SetLogLevel"
+	// Commands of the collector control
+)
+
+var (
+	ErrInvalidCommand = errors.New("invalid command")
+)
+
+// CollectorControl is the part of the collector configuration that can be changed at runtime with commands.
+type CollectorControl struct {
+	mutex sync.RWMutex
+	// Excluded parameter name prefixes by filter name
+	parameterFilters map[string][]string
+	pausedDevices    map[string]bool
+	logLevel         *slog.LevelVar
+}
+
+// NewCollectorControl creates the collector control. The log level variable must be the level of the handler of the logger, otherwise SetLogLevel commands fail.
+func NewCollectorControl(logLevel *slog.LevelVar) *CollectorControl {
+	return &CollectorControl{parameterFilters: map[string][]string{}, pausedDevices: map[string]bool{}, logLevel: logLevel}
+}
+
+func (c *CollectorControl) Apply(command *MQTTCommandModel) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch command.Command {
+	case Command_EnableParameterFilter:
+		if command.Name == "" || len(command.ParameterPrefixes) == 0 {
+			return ErrInvalidCommand
+		}
+
+		c.parameterFilters[command.Name] = command.ParameterPrefixes
+	case Command_DisableParameterFilter:
+		delete(c.parameterFilters, command.Name)
+	case Command_PauseDevice:
+		if command.DeviceName == "" {
+			return ErrInvalidCommand
+		}
+
+		c.pausedDevices[command.DeviceName] = true
+	case Command_ResumeDevice:
+		delete(c.pausedDevices, command.DeviceName)
+	case Command_SetLogLevel:
+		if c.logLevel == nil {
+			return ErrInvalidCommand
+		}
+
+		return c.logLevel.UnmarshalText([]byte(command.LogLevel))
+	default:
+		return ErrInvalidCommand
+	}
+
+	return nil
+}
+
+// IsPaused returns whether the reports of the device are dropped.
+func (c *CollectorControl) IsPaused(deviceName string) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.pausedDevices[deviceName]
+}
+
+// Filter returns the parameters not excluded by any of the enabled parameter filters.
+func (c *CollectorControl) Filter(parameters map[string]any) map[string]any {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if len(c.parameterFilters) == 0 {
+		return parameters
+	}
+
+	filteredParameters := make(map[string]any, len(parameters))
+
+	for parameterName, value := range parameters {
+		if !c.excludes(parameterName) {
+			filteredParameters[parameterName] = value
+		}
+	}
+
+	return filteredParameters
+}
+
+// excludes returns whether the parameter is excluded. The caller must hold the mutex.
+func (c *CollectorControl) excludes(parameterName string) bool {
+	for _, parameterPrefixes := range c.parameterFilters {
+		for _, parameterPrefix := range parameterPrefixes {
+			if strings.HasPrefix(parameterName, parameterPrefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+const (
+	// SignatureUserProperty is the MQTT v5 user property with the hex encoded HMAC-SHA256 of the command payload.
+	SignatureUserProperty = "Signature"
+
+	// Statuses of the command results
+	CommandStatus_OK    = "OK"
+	CommandStatus_Error = "Error"
+	// Statuses of the command results
+)
+
+var (
+	ErrMissingCommandSecret = errors.New("missing command secret")
+	ErrInvalidSignature     = errors.New("invalid signature")
+	ErrExpiredCommand       = errors.New("expired command")
+	ErrDuplicateCommand     = errors.New("duplicate command")
+)
+
+type MQTTCommandModel struct {
+	ID        string    `json:"ID"`
+	Timestamp time.Time `json:"Timestamp"`
+	Command   string    `json:"Command"`
+	// Name of the parameter filter
+	Name              string   `json:"Name,omitempty"`
+	ParameterPrefixes []string `json:"ParameterPrefixes,omitempty"`
+	DeviceName        string   `json:"DeviceName,omitempty"`
+	LogLevel          string   `json:"LogLevel,omitempty"`
+}
+
+type MQTTCommandResultModel struct {
+	ID     string `json:"ID"`
+	Status string `json:"Status"`
+	Error  string `json:"Error,omitempty"`
+}
+
+type CommandHandlerOptions struct {
+	CollectorName string
+	// Secret is the HMAC-SHA256 key the commands are signed with.
+	Secret []byte
+	// MaxAge is the maximum age of the accepted commands, which limits replays. Defaults to 5 minutes.
+	MaxAge time.Duration
+	Logger *slog.Logger
+}
+
+// CommandHandler subscribes to the command topic of the collector and applies the signed commands to the collector control. The result of each command is published to the response topic of the command, or to the default response topic, with the correlation data of the command.
+type CommandHandler struct {
+	options *CommandHandlerOptions
+	control *CollectorControl
+	logger  *slog.Logger
+	mutex   sync.Mutex
+	// Timestamps of the applied commands by ID, to reject replays within the maximum age
+	commandIDs map[string]time.Time
+}
+
+func NewCommandHandler(control *CollectorControl, options *CommandHandlerOptions) (*CommandHandler, error) {
+	if len(options.Secret) == 0 {
+		return nil, ErrMissingCommandSecret
+	}
+
+	logger := slog.Default()
+
+	if options.Logger != nil {
+		logger = options.Logger
+	}
+
+	return &CommandHandler{options: options, control: control, logger: logger, commandIDs: map[string]time.Time{}}, nil
+}
+
+func (h *CommandHandler) Topic() string {
+	return fmt.Sprintf("collector/%s/command", h.options.CollectorName)
+}
+
+func (h *CommandHandler) ResponseTopic() string {
+	return fmt.Sprintf("collector/%s/command/response", h.options.CollectorName)
+}
+
+// OnConnectionUp subscribes to the command topic.
+func (h *CommandHandler) OnConnectionUp(connectionManager *autopaho.ConnectionManager, connack *paho.Connack) {
+	subscribe := &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: h.Topic(), QoS: 1}},
+	}
+
+	if _, err := connectionManager.Subscribe(context.Background(), subscribe); err != nil {
+		h.logger.Error("Command topic subscription failed", "topic", h.Topic(), "error", err)
+	}
+}
+
+// OnPublishReceived handles the messages of the command topic.
+func (h *CommandHandler) OnPublishReceived(publishReceived autopaho.PublishReceived) (bool, error) {
+	publish := publishReceived.Packet
+
+	if publish.Topic != h.Topic() {
+		return false, nil
+	}
+
+	result := &MQTTCommandResultModel{Status: CommandStatus_OK}
+
+	command, err := h.verify(publish)
+
+	if err == nil {
+		result.ID = command.ID
+
+		err = h.control.Apply(command)
+	}
+
+	if err != nil {
+		result.Status = CommandStatus_Error
+		result.Error = err.Error()
+
+		h.logger.Warn("Command failed", "id", result.ID, "error", err)
+	} else {
+		h.logger.Info("Command applied", "id", command.ID, "command", command.Command)
+	}
+
+	if err := h.respond(publishReceived.ConnectionManager, publish, command != nil, result); err != nil {
+		h.logger.Error("Command response failed", "id", result.ID, "error", err)
+	}
+
+	return true, nil
+}
+
+// verify checks the signature, the age and the uniqueness of the command.
+func (h *CommandHandler) verify(publish *paho.Publish) (*MQTTCommandModel, error) {
+	if publish.Properties == nil {
+		return nil, ErrInvalidSignature
+	}
+
+	signature, err := hex.DecodeString(publish.Properties.User.Get(SignatureUserProperty))
+
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, h.options.Secret)
+
+	mac.Write(publish.Payload)
+
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrInvalidSignature
+	}
+
+	command := &MQTTCommandModel{}
+
+	if err := json.Unmarshal(publish.Payload, command); err != nil {
+		return nil, err
+	}
+
+	maxAge := 5 * time.Minute
+
+	if h.options.MaxAge > 0 {
+		maxAge = h.options.MaxAge
+	}
+
+	now := time.Now()
+
+	if command.Timestamp.Before(now.Add(-maxAge)) || command.Timestamp.After(now.Add(maxAge)) {
+		return nil, ErrExpiredCommand
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for commandID, timestamp := range h.commandIDs {
+		if timestamp.Before(now.Add(-maxAge)) {
+			delete(h.commandIDs, commandID)
+		}
+	}
+
+	if _, ok := h.commandIDs[command.ID]; ok {
+		return nil, ErrDuplicateCommand
+	}
+
+	h.commandIDs[command.ID] = command.Timestamp
+
+	return command, nil
+}
+
+// respond publishes the result of the command. The response topic and the correlation data of an unverified command are not trusted, so its result goes to the default response topic.
+func (h *CommandHandler) respond(connectionManager *autopaho.ConnectionManager, publish *paho.Publish, verified bool, result *MQTTCommandResultModel) error {
+	payload, err := json.Marshal(result)
+
+	if err != nil {
+		return err
+	}
+
+	responseTopic := h.ResponseTopic()
+
+	properties := &paho.PublishProperties{
+		ContentType: "application/json",
+	}
+
+	// MQTT v5 request/response
+	if verified && publish.Properties != nil {
+		if publish.Properties.ResponseTopic != "" {
+			responseTopic = publish.Properties.ResponseTopic
+		}
+
+		properties.CorrelationData = publish.Properties.CorrelationData
+	}
+
+	response := &autopaho.QueuePublish{
+		Publish: &paho.Publish{
+			Topic:      responseTopic,
+			QoS:        1,
+			Payload:    payload,
+			Properties: properties,
+		},
+	}
+
+	// Publishing via the queue does not block the handling of the received messages
+	return connectionManager.PublishViaQueue(context.Background(), response)
+}
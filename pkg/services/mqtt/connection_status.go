@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	// Reasons of the connection errors
+	connectionErrorReasonConnect          = "connect"
+	connectionErrorReasonClient           = "client"
+	connectionErrorReasonServerDisconnect = "server_disconnect"
+	// Reasons of the connection errors
+)
+
+// ConnectionStatus tracks whether the connection to the broker is up and exports it as metrics. Its methods are meant to be set as the callbacks of the autopaho client configuration.
+type ConnectionStatus struct {
+	up                 atomic.Bool
+	connectionCounter  metric.Int64Counter
+	errorCounter       metric.Int64Counter
+	statusRegistration metric.Registration
+}
+
+func NewConnectionStatus() (*ConnectionStatus, error) {
+	meter := otel.Meter(meterName)
+
+	connectionCounter, err := meter.Int64Counter("connection_counter", metric.WithDescription("Connection counter"), metric.WithUnit("count"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	errorCounter, err := meter.Int64Counter("connection_error_counter", metric.WithDescription("Connection error counter"), metric.WithUnit("count"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	statusGauge, err := meter.Int64ObservableGauge("connection_status_gauge", metric.WithDescription("Connection status gauge"), metric.WithUnit("count"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ConnectionStatus{connectionCounter: connectionCounter, errorCounter: errorCounter}
+
+	statusRegistration, err := meter.RegisterCallback(func(ctx context.Context, observer metric.Observer) error {
+		status := int64(0)
+
+		if c.up.Load() {
+			status = 1
+		}
+
+		observer.ObserveInt64(statusGauge, status)
+
+		return nil
+	}, statusGauge)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.statusRegistration = statusRegistration
+
+	return c, nil
+}
+
+func (c *ConnectionStatus) IsUp() bool {
+	return c.up.Load()
+}
+
+func (c *ConnectionStatus) OnConnectionUp(connectionManager *autopaho.ConnectionManager, connack *paho.Connack) {
+	c.up.Store(true)
+
+	c.connectionCounter.Add(context.Background(), 1)
+}
+
+func (c *ConnectionStatus) OnConnectError(err error) {
+	c.up.Store(false)
+
+	c.errorCounter.Add(context.Background(), 1, metric.WithAttributes(attribute.String("reason", connectionErrorReasonConnect)))
+}
+
+func (c *ConnectionStatus) OnClientError(err error) {
+	c.up.Store(false)
+
+	c.errorCounter.Add(context.Background(), 1, metric.WithAttributes(attribute.String("reason", connectionErrorReasonClient)))
+}
+
+func (c *ConnectionStatus) OnServerDisconnect(disconnect *paho.Disconnect) {
+	c.up.Store(false)
+
+	c.errorCounter.Add(context.Background(), 1, metric.WithAttributes(attribute.String("reason", connectionErrorReasonServerDisconnect)))
+}
+
+// Close unregisters the connection status metric.
+func (c *ConnectionStatus) Close() error {
+	return c.statusRegistration.Unregister()
+}
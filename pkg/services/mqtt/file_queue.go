@@ -0,0 +1,387 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/eclipse/paho.golang/autopaho/queue"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	meterName = "collector"
+
+	// Eviction policies of the file queue when it reaches its size limits
+	EvictionPolicyDropOldest = "DropOldest"
+	EvictionPolicyDropNewest = "DropNewest"
+	EvictionPolicyReject     = "Reject"
+	// Eviction policies of the file queue when it reaches its size limits
+
+	fileQueueExtension        = ".msg"
+	fileQueueTempExtension    = ".tmp"
+	fileQueueCorruptExtension = ".corrupt"
+)
+
+var (
+	ErrInvalidEvictionPolicy = errors.New("invalid eviction policy")
+	ErrQueueFull             = errors.New("queue full")
+)
+
+type FileQueueOptions struct {
+	Path string
+	// MaxCount is the maximum number of messages in the queue. Zero means no limit.
+	MaxCount int
+	// MaxBytes is the maximum total size of the messages in the queue. Zero means no limit.
+	MaxBytes int64
+	// EvictionPolicy defaults to EvictionPolicyDropOldest.
+	EvictionPolicy string
+}
+
+type fileQueueItem struct {
+	sequence uint64
+	size     int64
+}
+
+// FileQueue is a file-backed autopaho queue, so the messages queued while the broker is disconnected survive a restart. Each message is a file named after its sequence number, which keeps the order regardless of the file system timestamp resolution.
+type FileQueue struct {
+	mutex              sync.Mutex
+	options            *FileQueueOptions
+	evictionPolicy     string
+	items              []*fileQueueItem
+	bytes              int64
+	nextSequence       uint64
+	peekedSequence     uint64
+	waiting            []chan struct{}
+	evictedCounter     metric.Int64Counter
+	lengthRegistration metric.Registration
+}
+
+var _ queue.Queue = (*FileQueue)(nil)
+
+type fileQueueEntry struct {
+	queue *FileQueue
+	item  *fileQueueItem
+	file  *os.File
+}
+
+var _ queue.Entry = (*fileQueueEntry)(nil)
+
+func NewFileQueue(options *FileQueueOptions) (*FileQueue, error) {
+	evictionPolicy := EvictionPolicyDropOldest
+
+	if options.EvictionPolicy != "" {
+		evictionPolicy = options.EvictionPolicy
+	}
+
+	switch evictionPolicy {
+	case EvictionPolicyDropOldest, EvictionPolicyDropNewest, EvictionPolicyReject:
+	default:
+		return nil, ErrInvalidEvictionPolicy
+	}
+
+	if err := os.MkdirAll(options.Path, 0o770); err != nil {
+		return nil, err
+	}
+
+	q := &FileQueue{options: options, evictionPolicy: evictionPolicy, nextSequence: 1}
+
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+
+	meter := otel.Meter(meterName)
+
+	evictedCounter, err := meter.Int64Counter("queue_evicted_counter", metric.WithDescription("Queue evicted counter"), metric.WithUnit("count"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	lengthGauge, err := meter.Int64ObservableGauge("queue_length_gauge", metric.WithDescription("Queue length gauge"), metric.WithUnit("count"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	lengthRegistration, err := meter.RegisterCallback(func(ctx context.Context, observer metric.Observer) error {
+		q.mutex.Lock()
+		length := len(q.items)
+		q.mutex.Unlock()
+
+		observer.ObserveInt64(lengthGauge, int64(length))
+
+		return nil
+	}, lengthGauge)
+
+	if err != nil {
+		return nil, err
+	}
+
+	q.evictedCounter = evictedCounter
+	q.lengthRegistration = lengthRegistration
+
+	return q, nil
+}
+
+// Close unregisters the queue length metric. The queued messages stay on disk.
+func (q *FileQueue) Close() error {
+	return q.lengthRegistration.Unregister()
+}
+
+func (q *FileQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.items)
+}
+
+func (q *FileQueue) Wait() chan struct{} {
+	wait := make(chan struct{})
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.items) != 0 {
+		close(wait)
+
+		return wait
+	}
+
+	q.waiting = append(q.waiting, wait)
+
+	return wait
+}
+
+func (q *FileQueue) Enqueue(p io.Reader) error {
+	data, err := io.ReadAll(p)
+
+	if err != nil {
+		return err
+	}
+
+	size := int64(len(data))
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if !q.makeRoom(size) {
+		if q.evictionPolicy == EvictionPolicyReject {
+			return ErrQueueFull
+		}
+
+		// The message itself is evicted
+		q.evictedCounter.Add(context.Background(), 1, metric.WithAttributes(attribute.String("policy", q.evictionPolicy)))
+
+		return nil
+	}
+
+	item := &fileQueueItem{sequence: q.nextSequence, size: size}
+
+	fileName := q.fileName(item.sequence)
+
+	// The message appears in the queue only once it is completely written
+	if err := os.WriteFile(fileName+fileQueueTempExtension, data, 0o660); err != nil {
+		return err
+	}
+
+	if err := os.Rename(fileName+fileQueueTempExtension, fileName); err != nil {
+		return err
+	}
+
+	q.nextSequence++
+	q.items = append(q.items, item)
+	q.bytes += size
+
+	for _, wait := range q.waiting {
+		close(wait)
+	}
+
+	q.waiting = q.waiting[:0]
+
+	return nil
+}
+
+func (q *FileQueue) Peek() (queue.Entry, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.items) == 0 {
+		return nil, queue.ErrEmpty
+	}
+
+	item := q.items[0]
+
+	file, err := os.Open(q.fileName(item.sequence))
+
+	if err != nil {
+		return nil, err
+	}
+
+	q.peekedSequence = item.sequence
+
+	return &fileQueueEntry{queue: q, item: item, file: file}, nil
+}
+
+// makeRoom evicts messages according to the eviction policy until a message of the size fits in the size limits. It returns false if the message does not fit. The caller must hold the mutex.
+func (q *FileQueue) makeRoom(size int64) bool {
+	fits := func() bool {
+		return (q.options.MaxCount <= 0 || len(q.items) < q.options.MaxCount) && (q.options.MaxBytes <= 0 || q.bytes+size <= q.options.MaxBytes)
+	}
+
+	if q.options.MaxBytes > 0 && size > q.options.MaxBytes {
+		return false
+	}
+
+	if fits() {
+		return true
+	}
+
+	if q.evictionPolicy != EvictionPolicyDropOldest {
+		return false
+	}
+
+	for !fits() {
+		// The message being published is not evicted
+		index := slices.IndexFunc(q.items, func(item *fileQueueItem) bool { return item.sequence != q.peekedSequence })
+
+		if index < 0 {
+			return false
+		}
+
+		item := q.items[index]
+
+		if err := os.Remove(q.fileName(item.sequence)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false
+		}
+
+		q.delete(item)
+
+		q.evictedCounter.Add(context.Background(), 1, metric.WithAttributes(attribute.String("policy", q.evictionPolicy)))
+	}
+
+	return true
+}
+
+// load indexes the messages left on disk by a previous run. The caller must hold the mutex or own the queue.
+func (q *FileQueue) load() error {
+	dirEntries, err := os.ReadDir(q.options.Path)
+
+	if err != nil {
+		return err
+	}
+
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+
+		if dirEntry.IsDir() {
+			continue
+		}
+
+		// Messages partially written before a crash
+		if strings.HasSuffix(name, fileQueueTempExtension) {
+			if err := os.Remove(filepath.Join(q.options.Path, name)); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if !strings.HasSuffix(name, fileQueueExtension) {
+			continue
+		}
+
+		sequence, err := strconv.ParseUint(strings.TrimSuffix(name, fileQueueExtension), 10, 64)
+
+		if err != nil {
+			continue
+		}
+
+		fileInfo, err := dirEntry.Info()
+
+		if err != nil {
+			return err
+		}
+
+		q.items = append(q.items, &fileQueueItem{sequence: sequence, size: fileInfo.Size()})
+		q.bytes += fileInfo.Size()
+		q.nextSequence = max(q.nextSequence, sequence+1)
+	}
+
+	slices.SortFunc(q.items, func(a, b *fileQueueItem) int {
+		return cmp.Compare(a.sequence, b.sequence)
+	})
+
+	return nil
+}
+
+// delete removes the item from the index. The caller must hold the mutex.
+func (q *FileQueue) delete(item *fileQueueItem) {
+	if index := slices.Index(q.items, item); index >= 0 {
+		q.items = slices.Delete(q.items, index, index+1)
+		q.bytes -= item.size
+	}
+}
+
+func (q *FileQueue) fileName(sequence uint64) string {
+	return filepath.Join(q.options.Path, fmt.Sprintf("%020d%s", sequence, fileQueueExtension))
+}
+
+func (e *fileQueueEntry) Reader() (io.Reader, error) {
+	return e.file, nil
+}
+
+func (e *fileQueueEntry) Leave() error {
+	e.queue.mutex.Lock()
+	e.queue.peekedSequence = 0
+	e.queue.mutex.Unlock()
+
+	return e.file.Close()
+}
+
+func (e *fileQueueEntry) Remove() error {
+	closeErr := e.file.Close()
+
+	e.queue.mutex.Lock()
+	defer e.queue.mutex.Unlock()
+
+	e.queue.peekedSequence = 0
+
+	if err := os.Remove(e.file.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	e.queue.delete(e.item)
+
+	return closeErr
+}
+
+func (e *fileQueueEntry) Quarantine() error {
+	closeErr := e.file.Close()
+
+	e.queue.mutex.Lock()
+	defer e.queue.mutex.Unlock()
+
+	e.queue.peekedSequence = 0
+
+	// The quarantined message is kept on disk for inspection, but no longer loaded
+	if err := os.Rename(e.file.Name(), e.file.Name()+fileQueueCorruptExtension); err != nil {
+		if removeErr := os.Remove(e.file.Name()); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	e.queue.delete(e.item)
+
+	return closeErr
+}
@@ -3,15 +3,29 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/eclipse/paho.golang/autopaho"
 	"github.com/eclipse/paho.golang/paho"
 
+	"github.com/zdrgeo/bulk-data-collector/pkg/cloudevents"
+	"github.com/zdrgeo/bulk-data-collector/pkg/serializers"
 	"github.com/zdrgeo/bulk-data-collector/pkg/services"
 )
 
+const (
+	DefaultTopicTemplate = "collector/{{.CollectorName}}/device/{{.DeviceName}}/event"
+)
+
+var (
+	ErrInvalidQoS = errors.New("invalid QoS")
+)
+
 type MQTTEventModel struct {
 	CollectionTime time.Time      `json:"CollectionTime"`
 	OUI            string         `json:"OUI"`
@@ -20,19 +34,103 @@ type MQTTEventModel struct {
 	Parameters     map[string]any `json:"Parameters"`
 }
 
+// MQTTTopicModel is the data of the topic and user property templates.
+type MQTTTopicModel struct {
+	CollectorName string
+	OUI           string
+	ProductClass  string
+	SerialNumber  string
+	DeviceName    string
+	ReportFormat  string
+	// ParameterPrefix is the longest common object path of the parameters of the report, without the trailing dot.
+	ParameterPrefix string
+}
+
 type MQTTCollectorServiceOptions struct {
 	CollectorName string
+	Serializer    serializers.Serializer
+	CloudEvent    *cloudevents.CloudEventOptions
+	// TopicTemplate is a text/template over MQTTTopicModel. Defaults to DefaultTopicTemplate.
+	TopicTemplate string
+	// QoS defaults to 1.
+	QoS    *byte
+	Retain bool
+	// MessageExpiry is the lifetime of the messages in the broker, rounded up to whole seconds. Zero means the messages do not expire.
+	MessageExpiry time.Duration
+	// ContentType overrides the content type of the serializer.
+	ContentType string
+	// UserProperties are MQTT v5 user properties, whose values are text/templates over MQTTTopicModel, for example {{.SerialNumber}}.
+	UserProperties map[string]string
+	// ParameterTopics additionally publishes the parameters to retained topics when their values change.
+	ParameterTopics *MQTTParameterTopicsOptions
+	// SparkplugNode publishes the reports as Sparkplug B DDATA messages of the node instead.
+	SparkplugNode *SparkplugNode
+	// Control pauses devices and filters parameters at runtime.
+	Control *CollectorControl
 }
 
 type MQTTCollectorService struct {
-	connectionManager *autopaho.ConnectionManager
-	options           *MQTTCollectorServiceOptions
+	connectionManager     *autopaho.ConnectionManager
+	options               *MQTTCollectorServiceOptions
+	serializer            serializers.Serializer
+	topicTemplate         *template.Template
+	userPropertyTemplates map[string]*template.Template
+	qos                   byte
+	parameterTopics       *parameterTopics
 }
 
 var _ services.CollectorService = (*MQTTCollectorService)(nil)
 
-func NewMQTTCollectorService(connectionManager *autopaho.ConnectionManager, options *MQTTCollectorServiceOptions) *MQTTCollectorService {
-	return &MQTTCollectorService{connectionManager: connectionManager, options: options}
+func NewMQTTCollectorService(connectionManager *autopaho.ConnectionManager, options *MQTTCollectorServiceOptions) (*MQTTCollectorService, error) {
+	var serializer serializers.Serializer = serializers.NewJSONSerializer()
+
+	if options.Serializer != nil {
+		serializer = options.Serializer
+	}
+
+	topicTemplateText := DefaultTopicTemplate
+
+	if options.TopicTemplate != "" {
+		topicTemplateText = options.TopicTemplate
+	}
+
+	topicTemplate, err := template.New("topic").Option("missingkey=error").Parse(topicTemplateText)
+
+	if err != nil {
+		return nil, err
+	}
+
+	userPropertyTemplates := make(map[string]*template.Template, len(options.UserProperties))
+
+	for name, value := range options.UserProperties {
+		userPropertyTemplate, err := template.New(name).Option("missingkey=error").Parse(value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		userPropertyTemplates[name] = userPropertyTemplate
+	}
+
+	var qos byte = 1
+
+	if options.QoS != nil {
+		if *options.QoS > 2 {
+			return nil, ErrInvalidQoS
+		}
+
+		qos = *options.QoS
+	}
+
+	var parameterTopics *parameterTopics
+
+	if options.ParameterTopics != nil {
+		if parameterTopics, err = newParameterTopics(options.ParameterTopics); err != nil {
+			return nil, err
+		}
+	}
+
+	return &MQTTCollectorService{connectionManager: connectionManager, options: options, serializer: serializer, topicTemplate: topicTemplate, userPropertyTemplates: userPropertyTemplates, qos: qos, parameterTopics: parameterTopics}, nil
 }
 
 func (s *MQTTCollectorService) Collect(ctx context.Context, oui, productClass, serialNumber string, data *services.DataModel) error {
@@ -49,24 +147,7 @@ func (s *MQTTCollectorService) Collect(ctx context.Context, oui, productClass, s
 			event.Parameters[key] = value
 		}
 
-		deviceName := fmt.Sprintf("%s-%s-%s", oui, productClass, serialNumber)
-		topic := fmt.Sprintf("collector/%s/device/%s/event", s.options.CollectorName, deviceName)
-
-		payload, err := json.MarshalIndent(event, "", "  ")
-
-		if err != nil {
-			return err
-		}
-
-		publish := &autopaho.QueuePublish{
-			Publish: &paho.Publish{
-				Topic:   topic,
-				QoS:     1,
-				Payload: payload,
-			},
-		}
-
-		if err := s.connectionManager.PublishViaQueue(ctx, publish); err != nil {
+		if err := s.publish(ctx, event, "", nil); err != nil {
 			return err
 		}
 	}
@@ -81,6 +162,12 @@ func (s *MQTTCollectorService) CollectCSV(ctx context.Context, oui, productClass
 		reports[parameterPerRow.ReportTimestamp] = append(reports[parameterPerRow.ReportTimestamp], parameterPerRow)
 	}
 
+	parameterTypes := make(map[string]string, len(bulkData.ParameterPerRow))
+
+	for _, parameterPerRow := range bulkData.ParameterPerRow {
+		parameterTypes[parameterPerRow.ParameterName] = parameterPerRow.ParameterType
+	}
+
 	for reportTimestamp, report := range reports {
 		event := &MQTTEventModel{
 			CollectionTime: reportTimestamp,
@@ -100,24 +187,7 @@ func (s *MQTTCollectorService) CollectCSV(ctx context.Context, oui, productClass
 			event.Parameters[parameterPerRow.ParameterName] = value
 		}
 
-		deviceName := fmt.Sprintf("%s-%s-%s", oui, productClass, serialNumber)
-		topic := fmt.Sprintf("collector/%s/device/%s/event", s.options.CollectorName, deviceName)
-
-		payload, err := json.MarshalIndent(event, "", "  ")
-
-		if err != nil {
-			return err
-		}
-
-		publish := &autopaho.QueuePublish{
-			Publish: &paho.Publish{
-				Topic:   topic,
-				QoS:     1,
-				Payload: payload,
-			},
-		}
-
-		if err := s.connectionManager.PublishViaQueue(ctx, publish); err != nil {
+		if err := s.publish(ctx, event, services.ReportFormat_ParameterPerRow, parameterTypes); err != nil {
 			return err
 		}
 	}
@@ -140,28 +210,173 @@ func (s *MQTTCollectorService) CollectJSON(ctx context.Context, oui, productClas
 				event.Parameters[key] = value
 			}
 
-			deviceName := fmt.Sprintf("%s-%s-%s", oui, productClass, serialNumber)
-			topic := fmt.Sprintf("collector/%s/device/%s/event", s.options.CollectorName, deviceName)
-
-			payload, err := json.MarshalIndent(event, "", "  ")
-
-			if err != nil {
+			if err := s.publish(ctx, event, services.ReportFormat_NameValuePair, nil); err != nil {
 				return err
 			}
+		}
+	}
 
-			publish := &autopaho.QueuePublish{
-				Publish: &paho.Publish{
-					Topic:   topic,
-					QoS:     1,
-					Payload: payload,
-				},
-			}
+	return nil
+}
+
+// publish publishes the event. The TR-106 types of the parameters, if known, define the Sparkplug B data types of the metrics.
+func (s *MQTTCollectorService) publish(ctx context.Context, event *MQTTEventModel, reportFormat string, parameterTypes map[string]string) error {
+	deviceName := fmt.Sprintf("%s-%s-%s", event.OUI, event.ProductClass, event.SerialNumber)
 
-			if err := s.connectionManager.PublishViaQueue(ctx, publish); err != nil {
+	if s.options.Control != nil {
+		// The reports of the paused devices are accepted, but dropped
+		if s.options.Control.IsPaused(deviceName) {
+			return nil
+		}
+
+		event = &MQTTEventModel{
+			CollectionTime: event.CollectionTime,
+			OUI:            event.OUI,
+			ProductClass:   event.ProductClass,
+			SerialNumber:   event.SerialNumber,
+			Parameters:     s.options.Control.Filter(event.Parameters),
+		}
+	}
+
+	if s.options.SparkplugNode != nil {
+		return s.options.SparkplugNode.Publish(ctx, event, parameterTypes)
+	}
+
+	topicModel := &MQTTTopicModel{
+		CollectorName:   s.options.CollectorName,
+		OUI:             event.OUI,
+		ProductClass:    event.ProductClass,
+		SerialNumber:    event.SerialNumber,
+		DeviceName:      deviceName,
+		ReportFormat:    reportFormat,
+		ParameterPrefix: parameterPrefix(event.Parameters),
+	}
+
+	topic, err := execute(s.topicTemplate, topicModel)
+
+	if err != nil {
+		return err
+	}
+
+	payload, err := s.serializer.Serialize(ctx, (*services.EventModel)(event))
+
+	if err != nil {
+		return err
+	}
+
+	properties := &paho.PublishProperties{
+		ContentType: s.serializer.ContentType(),
+	}
+
+	if s.options.ContentType != "" {
+		properties.ContentType = s.options.ContentType
+	}
+
+	if s.options.MessageExpiry > 0 {
+		// The message expiry interval is in whole seconds, so a sub-second expiry rounds up instead of to zero, which would mean no expiry
+		messageExpiry := uint32(max(math.Ceil(s.options.MessageExpiry.Seconds()), 1))
+
+		properties.MessageExpiry = &messageExpiry
+	}
+
+	for name, userPropertyTemplate := range s.userPropertyTemplates {
+		value, err := execute(userPropertyTemplate, topicModel)
+
+		if err != nil {
+			return err
+		}
+
+		properties.User.Add(name, value)
+	}
+
+	if s.options.CloudEvent != nil {
+		cloudEvent := cloudevents.NewCloudEvent(s.options.CloudEvent.Source, cloudevents.NewType(reportFormat), deviceName, event.CollectionTime, properties.ContentType, payload)
+
+		if s.options.CloudEvent.Mode == cloudevents.ModeBinary {
+			// MQTT v5 protocol binding (https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/bindings/mqtt-protocol-binding.md)
+			for name, value := range cloudEvent.Attributes() {
+				if name != cloudevents.Attribute_DataContentType {
+					properties.User.Add(name, value)
+				}
+			}
+		} else {
+			if payload, err = json.Marshal(cloudEvent); err != nil {
 				return err
 			}
+
+			properties.ContentType = cloudevents.StructuredContentType
 		}
 	}
 
+	publish := &autopaho.QueuePublish{
+		Publish: &paho.Publish{
+			Topic:      topic,
+			QoS:        s.qos,
+			Retain:     s.options.Retain,
+			Payload:    payload,
+			Properties: properties,
+		},
+	}
+
+	// The parameters are published before the event, so a failure of the parameters fails the report before the event is queued and the retry of the device does not duplicate the event
+	if s.parameterTopics != nil {
+		if err := s.publishParameters(ctx, event, topicModel); err != nil {
+			return err
+		}
+	}
+
+	if err := s.connectionManager.PublishViaQueue(ctx, publish); err != nil {
+		if errors.Is(err, ErrQueueFull) {
+			return fmt.Errorf("%w: %w", services.ErrBackpressure, err)
+		}
+
+		return err
+	}
+
 	return nil
 }
+
+func execute(textTemplate *template.Template, topicModel *MQTTTopicModel) (string, error) {
+	builder := &strings.Builder{}
+
+	if err := textTemplate.Execute(builder, topicModel); err != nil {
+		return "", err
+	}
+
+	return builder.String(), nil
+}
+
+// parameterPrefix returns the longest common object path of the parameter names, for example Device.WiFi.Radio.1 for Device.WiFi.Radio.1.Stats.Noise and Device.WiFi.Radio.1.Channel.
+func parameterPrefix(parameters map[string]any) string {
+	var prefix []string
+
+	first := true
+
+	for parameterName := range parameters {
+		// Names without object path, like CollectionTime, are not parameters of the data model
+		if !strings.Contains(parameterName, ".") {
+			continue
+		}
+
+		// The last segment is the parameter itself, not an object
+		segments := strings.Split(parameterName, ".")
+		segments = segments[:len(segments)-1]
+
+		if first {
+			prefix = segments
+			first = false
+
+			continue
+		}
+
+		length := 0
+
+		for length < len(prefix) && length < len(segments) && prefix[length] == segments[length] {
+			length++
+		}
+
+		prefix = prefix[:length]
+	}
+
+	return strings.Join(prefix, ".")
+}
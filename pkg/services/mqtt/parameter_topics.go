@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+
+	"github.com/zdrgeo/bulk-data-collector/pkg/services"
+)
+
+const (
+	DefaultParameterTopicTemplate = "collector/{{.CollectorName}}/device/{{.DeviceName}}/parameter/{{.ParameterPath}}"
+
+	parameterContentType = "application/json"
+)
+
+// MQTTParameterTopicModel is the data of the parameter topic template.
+type MQTTParameterTopicModel struct {
+	MQTTTopicModel
+	ParameterName string
+	// ParameterPath is the parameter name with slashes instead of dots, for example Device/DeviceInfo/ProcessStatus/CPUUsage.
+	ParameterPath string
+}
+
+type MQTTParameterTopicsOptions struct {
+	// TopicTemplate is a text/template over MQTTParameterTopicModel. Defaults to DefaultParameterTopicTemplate.
+	TopicTemplate string
+	// ParameterPrefixes selects the parameters by name prefix, for example Device.DeviceInfo.ProcessStatus. Empty selects all parameters.
+	ParameterPrefixes []string
+	// StateTTL is the time without reports after which the last known values of a device are forgotten. Defaults to 24 hours.
+	StateTTL time.Duration
+}
+
+type MQTTParameterModel struct {
+	CollectionTime time.Time `json:"CollectionTime"`
+	Value          any       `json:"Value"`
+}
+
+type parameterTopicsDevice struct {
+	// Last known values by parameter name
+	parameters map[string]*MQTTParameterModel
+	updateTime time.Time
+}
+
+// parameterTopics publishes the parameters of the devices to retained topics when their values change, so digital twin consumers get the last known values on subscription. The devices without reports for longer than the TTL are forgotten, and their parameters are published again with their next report.
+type parameterTopics struct {
+	options       *MQTTParameterTopicsOptions
+	topicTemplate *template.Template
+	mutex         sync.Mutex
+	devices       map[string]*parameterTopicsDevice
+	ttl           time.Duration
+	pruneTime     time.Time
+}
+
+func newParameterTopics(options *MQTTParameterTopicsOptions) (*parameterTopics, error) {
+	topicTemplateText := DefaultParameterTopicTemplate
+
+	if options.TopicTemplate != "" {
+		topicTemplateText = options.TopicTemplate
+	}
+
+	topicTemplate, err := template.New("parameter_topic").Option("missingkey=error").Parse(topicTemplateText)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := 24 * time.Hour
+
+	if options.StateTTL > 0 {
+		ttl = options.StateTTL
+	}
+
+	return &parameterTopics{options: options, topicTemplate: topicTemplate, devices: map[string]*parameterTopicsDevice{}, ttl: ttl, pruneTime: time.Now()}, nil
+}
+
+// changes returns the names of the selected parameters of the event whose values differ from the last known values. Reports older than the last known values have no changes.
+func (t *parameterTopics) changes(event *MQTTEventModel, deviceName string) []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+
+	t.prune(now)
+
+	var deviceParameters map[string]*MQTTParameterModel
+
+	if device, ok := t.devices[deviceName]; ok {
+		device.updateTime = now
+
+		deviceParameters = device.parameters
+	}
+
+	parameterNames := []string{}
+
+	for parameterName, value := range event.Parameters {
+		if !t.selects(parameterName) {
+			continue
+		}
+
+		parameter, ok := deviceParameters[parameterName]
+
+		if ok && (event.CollectionTime.Before(parameter.CollectionTime) || reflect.DeepEqual(parameter.Value, value)) {
+			continue
+		}
+
+		parameterNames = append(parameterNames, parameterName)
+	}
+
+	slices.Sort(parameterNames)
+
+	return parameterNames
+}
+
+// record remembers the published value of the parameter as its last known value, unless a newer value is already known.
+func (t *parameterTopics) record(deviceName, parameterName string, parameter *MQTTParameterModel) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	device, ok := t.devices[deviceName]
+
+	if !ok {
+		device = &parameterTopicsDevice{parameters: map[string]*MQTTParameterModel{}}
+
+		t.devices[deviceName] = device
+	}
+
+	device.updateTime = time.Now()
+
+	if lastParameter, ok := device.parameters[parameterName]; ok && parameter.CollectionTime.Before(lastParameter.CollectionTime) {
+		return
+	}
+
+	device.parameters[parameterName] = parameter
+}
+
+// prune forgets the devices without reports for longer than the TTL. It scans the devices at most once per half TTL.
+func (t *parameterTopics) prune(now time.Time) {
+	if now.Sub(t.pruneTime) < t.ttl/2 {
+		return
+	}
+
+	t.pruneTime = now
+
+	for deviceName, device := range t.devices {
+		if now.Sub(device.updateTime) > t.ttl {
+			delete(t.devices, deviceName)
+		}
+	}
+}
+
+func (t *parameterTopics) selects(parameterName string) bool {
+	// Names without object path, like CollectionTime, are not parameters of the data model
+	if !strings.Contains(parameterName, ".") {
+		return false
+	}
+
+	if len(t.options.ParameterPrefixes) == 0 {
+		return true
+	}
+
+	for _, parameterPrefix := range t.options.ParameterPrefixes {
+		if strings.HasPrefix(parameterName, parameterPrefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// publishParameters publishes the changed parameters of the event to their retained topics. Only the published values are remembered, so the parameters not published because of an error are published again with the next report.
+func (s *MQTTCollectorService) publishParameters(ctx context.Context, event *MQTTEventModel, topicModel *MQTTTopicModel) error {
+	for _, parameterName := range s.parameterTopics.changes(event, topicModel.DeviceName) {
+		parameterTopicModel := &MQTTParameterTopicModel{
+			MQTTTopicModel: *topicModel,
+			ParameterName:  parameterName,
+			ParameterPath:  strings.ReplaceAll(parameterName, ".", "/"),
+		}
+
+		builder := &strings.Builder{}
+
+		if err := s.parameterTopics.topicTemplate.Execute(builder, parameterTopicModel); err != nil {
+			return err
+		}
+
+		parameter := &MQTTParameterModel{CollectionTime: event.CollectionTime, Value: event.Parameters[parameterName]}
+
+		payload, err := json.Marshal(parameter)
+
+		if err != nil {
+			return err
+		}
+
+		publish := &autopaho.QueuePublish{
+			Publish: &paho.Publish{
+				Topic:   builder.String(),
+				QoS:     s.qos,
+				Retain:  true,
+				Payload: payload,
+				Properties: &paho.PublishProperties{
+					ContentType: parameterContentType,
+				},
+			},
+		}
+
+		if err := s.connectionManager.PublishViaQueue(ctx, publish); err != nil {
+			if errors.Is(err, ErrQueueFull) {
+				return fmt.Errorf("%w: %w", services.ErrBackpressure, err)
+			}
+
+			return err
+		}
+
+		s.parameterTopics.record(topicModel.DeviceName, parameterName, parameter)
+	}
+
+	return nil
+}
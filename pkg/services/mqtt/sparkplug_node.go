@@ -0,0 +1,443 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+
+	"github.com/zdrgeo/bulk-data-collector/pkg/services"
+)
+
+// Sparkplug B topic namespace (https://sparkplug.eclipse.org/specification/version/3.0/documents/sparkplug-specification-3.0.0.pdf)
+
+const (
+	sparkplugNamespace = "spBv1.0"
+
+	// Sparkplug B message types
+	sparkplugMessageTypeNBIRTH = "NBIRTH"
+	sparkplugMessageTypeNDEATH = "NDEATH"
+	sparkplugMessageTypeNCMD   = "NCMD"
+	sparkplugMessageTypeDBIRTH = "DBIRTH"
+	sparkplugMessageTypeDDATA  = "DDATA"
+	sparkplugMessageTypeDDEATH = "DDEATH"
+	// Sparkplug B message types
+
+	sparkplugMetricBdSeq   = "bdSeq"
+	sparkplugMetricRebirth = "Node Control/Rebirth"
+)
+
+var (
+	ErrInvalidSparkplugID = errors.New("invalid Sparkplug ID")
+)
+
+type SparkplugNodeOptions struct {
+	GroupID    string
+	EdgeNodeID string
+	// DeviceTimeout is the silence after which a device is declared dead with DDEATH. Defaults to 10 minutes.
+	DeviceTimeout time.Duration
+	Logger        *slog.Logger
+}
+
+type sparkplugDevice struct {
+	born     bool
+	lastSeen time.Time
+	// Last known values of the metrics, sent again with every DBIRTH
+	metrics map[string]*sparkplugMetric
+}
+
+// SparkplugNode models the collector as a Sparkplug B edge node and each device as a Sparkplug B device of the node. It registers the NDEATH will message of the node before each connection, subscribes to the NCMD topic of the node and publishes the NBIRTH of the node and the DBIRTH of the known devices after each connection and on each rebirth request, so ConnectPacketBuilder and OnConnectionUp must be set in the autopaho client configuration and OnPublishReceived must be added to the connection.
+type SparkplugNode struct {
+	options *SparkplugNodeOptions
+	logger  *slog.Logger
+	// mutex guards the state of the node and its devices, but is not held across the publishes
+	mutex             sync.Mutex
+	connectionManager *autopaho.ConnectionManager
+	born              bool
+	bdSeq             uint64
+	nextBdSeq         uint64
+	devices           map[string]*sparkplugDevice
+	// publishMutex guards the sequence number, so the messages are published in the order of their sequence numbers
+	publishMutex sync.Mutex
+	seq          uint64
+}
+
+func NewSparkplugNode(options *SparkplugNodeOptions) (*SparkplugNode, error) {
+	if !isValidSparkplugID(options.GroupID) || !isValidSparkplugID(options.EdgeNodeID) {
+		return nil, ErrInvalidSparkplugID
+	}
+
+	logger := slog.Default()
+
+	if options.Logger != nil {
+		logger = options.Logger
+	}
+
+	return &SparkplugNode{options: options, logger: logger, devices: map[string]*sparkplugDevice{}}, nil
+}
+
+// ConnectPacketBuilder registers the NDEATH will message with the bdSeq of the connection.
+func (n *SparkplugNode) ConnectPacketBuilder(connect *paho.Connect, serverURL *url.URL) (*paho.Connect, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.born = false
+	n.bdSeq = n.nextBdSeq
+	n.nextBdSeq = (n.nextBdSeq + 1) % 256
+
+	payload := &sparkplugPayload{
+		timestamp: time.Now(),
+		metrics:   []*sparkplugMetric{{name: sparkplugMetricBdSeq, timestamp: time.Now(), dataType: sparkplugDataTypeInt64, value: int64(n.bdSeq)}},
+	}
+
+	connect.WillMessage = &paho.WillMessage{
+		Topic:   n.topic(sparkplugMessageTypeNDEATH, ""),
+		QoS:     1,
+		Payload: appendSparkplugPayload(nil, payload),
+	}
+
+	return connect, nil
+}
+
+// OnConnectionUp subscribes to the NCMD topic of the node and starts publishing the NBIRTH of the node and the DBIRTH of the known devices.
+func (n *SparkplugNode) OnConnectionUp(connectionManager *autopaho.ConnectionManager, connack *paho.Connack) {
+	n.mutex.Lock()
+	n.connectionManager = connectionManager
+	n.mutex.Unlock()
+
+	subscribe := &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: n.topic(sparkplugMessageTypeNCMD, ""), QoS: 1}},
+	}
+
+	if _, err := connectionManager.Subscribe(context.Background(), subscribe); err != nil {
+		n.logger.Error("Sparkplug NCMD topic subscription failed", "topic", n.topic(sparkplugMessageTypeNCMD, ""), "error", err)
+	}
+
+	// The callback does not block the connection manager while the births are published
+	go n.birth(context.Background())
+}
+
+// OnPublishReceived handles the rebirth requests of the NCMD topic of the node.
+func (n *SparkplugNode) OnPublishReceived(publishReceived autopaho.PublishReceived) (bool, error) {
+	publish := publishReceived.Packet
+
+	if publish.Topic != n.topic(sparkplugMessageTypeNCMD, "") {
+		return false, nil
+	}
+
+	rebirth, err := consumeSparkplugRebirth(publish.Payload)
+
+	if err != nil {
+		n.logger.Warn("Sparkplug NCMD invalid", "error", err)
+
+		return true, nil
+	}
+
+	if rebirth {
+		n.logger.Info("Sparkplug rebirth requested")
+
+		// The births are published outside of the handling of the received messages
+		go n.birth(context.Background())
+	}
+
+	return true, nil
+}
+
+// Publish publishes the parameters of the event as the metrics of the device in a DDATA message. The device is born with a DBIRTH message first, if it is new, or if the event contains new metrics.
+func (n *SparkplugNode) Publish(ctx context.Context, event *MQTTEventModel, parameterTypes map[string]string) error {
+	deviceID := sparkplugID(fmt.Sprintf("%s-%s-%s", event.OUI, event.ProductClass, event.SerialNumber))
+
+	n.mutex.Lock()
+
+	if !n.born {
+		n.mutex.Unlock()
+
+		return fmt.Errorf("%w: %w", services.ErrUnavailable, autopaho.ConnectionDownError)
+	}
+
+	bdSeq := n.bdSeq
+
+	device, ok := n.devices[deviceID]
+
+	if !ok {
+		device = &sparkplugDevice{metrics: map[string]*sparkplugMetric{}}
+
+		n.devices[deviceID] = device
+	}
+
+	device.lastSeen = time.Now()
+
+	parameterNames := make([]string, 0, len(event.Parameters))
+
+	for parameterName := range event.Parameters {
+		// Names without object path, like CollectionTime, are not parameters of the data model
+		if strings.Contains(parameterName, ".") {
+			parameterNames = append(parameterNames, parameterName)
+		}
+	}
+
+	slices.Sort(parameterNames)
+
+	metrics := make([]*sparkplugMetric, 0, len(parameterNames))
+
+	rebirth := !device.born
+
+	for _, parameterName := range parameterNames {
+		metric := newSparkplugMetric(parameterName, parameterTypes[parameterName], event.Parameters[parameterName], event.CollectionTime)
+
+		knownMetric, ok := device.metrics[parameterName]
+
+		// A null value keeps the data type of the metric
+		if ok && metric.value == nil {
+			metric.dataType = knownMetric.dataType
+		}
+
+		// The metrics of a device are defined by its DBIRTH
+		if !ok || knownMetric.dataType != metric.dataType {
+			rebirth = true
+		}
+
+		device.metrics[parameterName] = metric
+
+		metrics = append(metrics, metric)
+	}
+
+	messageType := sparkplugMessageTypeDDATA
+	payload := &sparkplugPayload{timestamp: time.Now(), metrics: metrics}
+
+	if rebirth {
+		// The concurrent reports of the device are published as DBIRTH too, until one of them succeeds
+		device.born = false
+
+		messageType = sparkplugMessageTypeDBIRTH
+		payload = newSparkplugDeviceBirthPayload(device)
+	}
+
+	n.mutex.Unlock()
+
+	err := n.publish(ctx, messageType, deviceID, payload)
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	device.born = err == nil
+
+	if err != nil {
+		if errors.Is(err, autopaho.ConnectionDownError) {
+			// A failure of a previous connection does not concern the current one
+			if n.bdSeq == bdSeq {
+				n.born = false
+			}
+
+			return fmt.Errorf("%w: %w", services.ErrUnavailable, err)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Run declares the devices that have been silent for longer than the device timeout dead with DDEATH messages.
+func (n *SparkplugNode) Run(ctx context.Context) error {
+	deviceTimeout := 10 * time.Minute
+
+	if n.options.DeviceTimeout > 0 {
+		deviceTimeout = n.options.DeviceTimeout
+	}
+
+	ticker := time.NewTicker(max(deviceTimeout/10, time.Second))
+
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-ticker.C:
+			n.expire(ctx, deviceTimeout)
+		}
+	}
+}
+
+// expire publishes the DDEATH of the silent devices and forgets them.
+func (n *SparkplugNode) expire(ctx context.Context, deviceTimeout time.Duration) {
+	n.mutex.Lock()
+
+	deviceIDs := []string{}
+
+	for deviceID, device := range n.devices {
+		if time.Since(device.lastSeen) < deviceTimeout {
+			continue
+		}
+
+		// A device that is not born needs no DDEATH
+		if n.born && device.born {
+			deviceIDs = append(deviceIDs, deviceID)
+		} else {
+			delete(n.devices, deviceID)
+		}
+	}
+
+	n.mutex.Unlock()
+
+	for _, deviceID := range deviceIDs {
+		if err := n.publish(ctx, sparkplugMessageTypeDDEATH, deviceID, &sparkplugPayload{timestamp: time.Now()}); err != nil {
+			continue
+		}
+
+		n.mutex.Lock()
+
+		// A device that reported in the meantime is alive again and born with its next report
+		if device, ok := n.devices[deviceID]; ok {
+			if time.Since(device.lastSeen) < deviceTimeout {
+				device.born = false
+			} else {
+				delete(n.devices, deviceID)
+			}
+		}
+
+		n.mutex.Unlock()
+	}
+}
+
+// birth publishes the NBIRTH of the node, which restarts the sequence numbers, and the DBIRTH of the known devices. It holds the publish mutex throughout, so no message of the node is published between them.
+func (n *SparkplugNode) birth(ctx context.Context) {
+	n.publishMutex.Lock()
+	defer n.publishMutex.Unlock()
+
+	n.mutex.Lock()
+	bdSeq := n.bdSeq
+	n.mutex.Unlock()
+
+	n.seq = 0
+
+	payload := &sparkplugPayload{
+		timestamp: time.Now(),
+		metrics: []*sparkplugMetric{
+			{name: sparkplugMetricBdSeq, timestamp: time.Now(), dataType: sparkplugDataTypeInt64, value: int64(bdSeq)},
+			{name: sparkplugMetricRebirth, timestamp: time.Now(), dataType: sparkplugDataTypeBoolean, value: false},
+		},
+	}
+
+	err := n.publishLocked(ctx, sparkplugMessageTypeNBIRTH, "", payload)
+
+	n.mutex.Lock()
+
+	// A reconnection in the meantime births the node again
+	if n.bdSeq != bdSeq {
+		n.mutex.Unlock()
+
+		return
+	}
+
+	n.born = err == nil
+
+	if err != nil {
+		n.mutex.Unlock()
+
+		return
+	}
+
+	payloads := make(map[string]*sparkplugPayload, len(n.devices))
+
+	for deviceID, device := range n.devices {
+		payloads[deviceID] = newSparkplugDeviceBirthPayload(device)
+	}
+
+	n.mutex.Unlock()
+
+	for deviceID, payload := range payloads {
+		err := n.publishLocked(ctx, sparkplugMessageTypeDBIRTH, deviceID, payload)
+
+		n.mutex.Lock()
+
+		if device, ok := n.devices[deviceID]; ok {
+			device.born = err == nil
+		}
+
+		n.mutex.Unlock()
+	}
+}
+
+// newSparkplugDeviceBirthPayload returns the DBIRTH payload of the device with the last known values of all its metrics. The caller must hold the mutex.
+func newSparkplugDeviceBirthPayload(device *sparkplugDevice) *sparkplugPayload {
+	metricNames := make([]string, 0, len(device.metrics))
+
+	for metricName := range device.metrics {
+		metricNames = append(metricNames, metricName)
+	}
+
+	slices.Sort(metricNames)
+
+	metrics := make([]*sparkplugMetric, 0, len(metricNames))
+
+	for _, metricName := range metricNames {
+		metrics = append(metrics, device.metrics[metricName])
+	}
+
+	return &sparkplugPayload{timestamp: time.Now(), metrics: metrics}
+}
+
+// publish publishes the message with the next sequence number. The messages are published directly, rather than via the queue, because the sequence numbers and the births are only valid within the current connection.
+func (n *SparkplugNode) publish(ctx context.Context, messageType, deviceID string, payload *sparkplugPayload) error {
+	n.publishMutex.Lock()
+	defer n.publishMutex.Unlock()
+
+	return n.publishLocked(ctx, messageType, deviceID, payload)
+}
+
+// publishLocked publishes the message with the next sequence number. The caller must hold the publish mutex.
+func (n *SparkplugNode) publishLocked(ctx context.Context, messageType, deviceID string, payload *sparkplugPayload) error {
+	n.mutex.Lock()
+	connectionManager := n.connectionManager
+	n.mutex.Unlock()
+
+	if connectionManager == nil {
+		return autopaho.ConnectionDownError
+	}
+
+	seq := n.seq
+
+	payload.seq = &seq
+
+	publish := &paho.Publish{
+		Topic:   n.topic(messageType, deviceID),
+		QoS:     0,
+		Payload: appendSparkplugPayload(nil, payload),
+	}
+
+	if _, err := connectionManager.Publish(ctx, publish); err != nil {
+		return err
+	}
+
+	n.seq = (n.seq + 1) % 256
+
+	return nil
+}
+
+func (n *SparkplugNode) topic(messageType, deviceID string) string {
+	if deviceID == "" {
+		return strings.Join([]string{sparkplugNamespace, n.options.GroupID, messageType, n.options.EdgeNodeID}, "/")
+	}
+
+	return strings.Join([]string{sparkplugNamespace, n.options.GroupID, messageType, n.options.EdgeNodeID, deviceID}, "/")
+}
+
+func isValidSparkplugID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "/+#")
+}
+
+// sparkplugID replaces the characters that are not allowed in Sparkplug B IDs.
+func sparkplugID(id string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(id)
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/zdrgeo/bulk-data-collector/pkg/services"
+)
+
+// Sparkplug B payload encoding (https://github.com/eclipse-sparkplug/sparkplug/blob/master/sparkplug_b/sparkplug_b.proto)
+
+const (
+	// Sparkplug B data types
+	sparkplugDataTypeInt32    = 3
+	sparkplugDataTypeInt64    = 4
+	sparkplugDataTypeUInt32   = 7
+	sparkplugDataTypeUInt64   = 8
+	sparkplugDataTypeDouble   = 10
+	sparkplugDataTypeBoolean  = 11
+	sparkplugDataTypeString   = 12
+	sparkplugDataTypeDateTime = 13
+	// Sparkplug B data types
+
+	// Payload fields
+	sparkplugPayloadTimestamp = 1
+	sparkplugPayloadMetrics   = 2
+	sparkplugPayloadSeq       = 3
+	// Payload fields
+
+	// Metric fields
+	sparkplugMetricName         = 1
+	sparkplugMetricTimestamp    = 3
+	sparkplugMetricDataType     = 4
+	sparkplugMetricIsNull       = 7
+	sparkplugMetricIntValue     = 10
+	sparkplugMetricLongValue    = 11
+	sparkplugMetricDoubleValue  = 13
+	sparkplugMetricBooleanValue = 14
+	sparkplugMetricStringValue  = 15
+	// Metric fields
+)
+
+type sparkplugMetric struct {
+	name      string
+	timestamp time.Time
+	dataType  uint32
+	value     any
+}
+
+type sparkplugPayload struct {
+	timestamp time.Time
+	metrics   []*sparkplugMetric
+	// seq is omitted from the NDEATH payload
+	seq *uint64
+}
+
+// newSparkplugMetric derives the data type of the metric from the TR-106 type of the parameter, if known, otherwise from the value.
+func newSparkplugMetric(name, parameterType string, value any, timestamp time.Time) *sparkplugMetric {
+	metric := &sparkplugMetric{name: name, timestamp: timestamp}
+
+	switch v := value.(type) {
+	case nil:
+		metric.dataType = sparkplugDataTypeString
+	case bool:
+		metric.dataType, metric.value = sparkplugDataTypeBoolean, v
+	case int:
+		metric.dataType, metric.value = sparkplugDataTypeInt64, int64(v)
+	case int32:
+		metric.dataType, metric.value = sparkplugDataTypeInt64, int64(v)
+	case int64:
+		metric.dataType, metric.value = sparkplugDataTypeInt64, v
+	case uint:
+		metric.dataType, metric.value = sparkplugDataTypeUInt64, uint64(v)
+	case uint32:
+		metric.dataType, metric.value = sparkplugDataTypeUInt64, uint64(v)
+	case uint64:
+		metric.dataType, metric.value = sparkplugDataTypeUInt64, v
+	case float32:
+		metric.dataType, metric.value = sparkplugDataTypeDouble, float64(v)
+	case float64:
+		metric.dataType, metric.value = sparkplugDataTypeDouble, v
+	case string:
+		metric.dataType, metric.value = sparkplugDataTypeString, v
+	case time.Time:
+		metric.dataType, metric.value = sparkplugDataTypeDateTime, v
+	default:
+		data, _ := json.Marshal(v)
+
+		metric.dataType, metric.value = sparkplugDataTypeString, string(data)
+	}
+
+	switch parameterType {
+	case services.ParameterType_int:
+		if _, ok := metric.value.(int64); ok {
+			metric.dataType = sparkplugDataTypeInt32
+		}
+	case services.ParameterType_unsignedInt:
+		if _, ok := metric.value.(uint64); ok {
+			metric.dataType = sparkplugDataTypeUInt32
+		}
+	}
+
+	return metric
+}
+
+func appendSparkplugPayload(b []byte, payload *sparkplugPayload) []byte {
+	b = protowire.AppendTag(b, sparkplugPayloadTimestamp, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(payload.timestamp.UnixMilli()))
+
+	for _, metric := range payload.metrics {
+		b = protowire.AppendTag(b, sparkplugPayloadMetrics, protowire.BytesType)
+		b = protowire.AppendBytes(b, appendSparkplugMetric(nil, metric))
+	}
+
+	if payload.seq != nil {
+		b = protowire.AppendTag(b, sparkplugPayloadSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, *payload.seq)
+	}
+
+	return b
+}
+
+func appendSparkplugMetric(b []byte, metric *sparkplugMetric) []byte {
+	b = protowire.AppendTag(b, sparkplugMetricName, protowire.BytesType)
+	b = protowire.AppendString(b, metric.name)
+
+	b = protowire.AppendTag(b, sparkplugMetricTimestamp, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(metric.timestamp.UnixMilli()))
+
+	b = protowire.AppendTag(b, sparkplugMetricDataType, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(metric.dataType))
+
+	switch v := metric.value.(type) {
+	case nil:
+		b = protowire.AppendTag(b, sparkplugMetricIsNull, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(true))
+	case bool:
+		b = protowire.AppendTag(b, sparkplugMetricBooleanValue, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(v))
+	case int64:
+		if metric.dataType == sparkplugDataTypeInt32 {
+			// Signed integers are carried as their two's complement
+			b = protowire.AppendTag(b, sparkplugMetricIntValue, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(uint32(int32(v))))
+		} else {
+			b = protowire.AppendTag(b, sparkplugMetricLongValue, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(v))
+		}
+	case uint64:
+		if metric.dataType == sparkplugDataTypeUInt32 {
+			b = protowire.AppendTag(b, sparkplugMetricIntValue, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(uint32(v)))
+		} else {
+			b = protowire.AppendTag(b, sparkplugMetricLongValue, protowire.VarintType)
+			b = protowire.AppendVarint(b, v)
+		}
+	case float64:
+		b = protowire.AppendTag(b, sparkplugMetricDoubleValue, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(v))
+	case string:
+		b = protowire.AppendTag(b, sparkplugMetricStringValue, protowire.BytesType)
+		b = protowire.AppendString(b, v)
+	case time.Time:
+		b = protowire.AppendTag(b, sparkplugMetricLongValue, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v.UnixMilli()))
+	}
+
+	return b
+}
+
+// consumeSparkplugRebirth reports whether the payload contains the Node Control/Rebirth metric with the value true.
+func consumeSparkplugRebirth(b []byte) (bool, error) {
+	for len(b) > 0 {
+		number, fieldType, n := protowire.ConsumeTag(b)
+
+		if n < 0 {
+			return false, protowire.ParseError(n)
+		}
+
+		b = b[n:]
+
+		if number != sparkplugPayloadMetrics || fieldType != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(number, fieldType, b); n < 0 {
+				return false, protowire.ParseError(n)
+			}
+
+			b = b[n:]
+
+			continue
+		}
+
+		metric, n := protowire.ConsumeBytes(b)
+
+		if n < 0 {
+			return false, protowire.ParseError(n)
+		}
+
+		b = b[n:]
+
+		rebirth, err := consumeSparkplugRebirthMetric(metric)
+
+		if err != nil {
+			return false, err
+		}
+
+		if rebirth {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func consumeSparkplugRebirthMetric(b []byte) (bool, error) {
+	var name string
+	var value bool
+
+	for len(b) > 0 {
+		number, fieldType, n := protowire.ConsumeTag(b)
+
+		if n < 0 {
+			return false, protowire.ParseError(n)
+		}
+
+		b = b[n:]
+
+		switch {
+		case number == sparkplugMetricName && fieldType == protowire.BytesType:
+			name, n = protowire.ConsumeString(b)
+		case number == sparkplugMetricBooleanValue && fieldType == protowire.VarintType:
+			var v uint64
+
+			v, n = protowire.ConsumeVarint(b)
+			value = protowire.DecodeBool(v)
+		default:
+			n = protowire.ConsumeFieldValue(number, fieldType, b)
+		}
+
+		if n < 0 {
+			return false, protowire.ParseError(n)
+		}
+
+		b = b[n:]
+	}
+
+	return name == sparkplugMetricRebirth && value, nil
+}
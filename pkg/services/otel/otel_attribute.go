@@ -0,0 +1,72 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/zdrgeo/bulk-data-collector/pkg/services"
+)
+
+const (
+	// Attributes of the device
+	AttributeKey_OUI          attribute.Key = "OUI"
+	AttributeKey_ProductClass attribute.Key = "ProductClass"
+	AttributeKey_SerialNumber attribute.Key = "SerialNumber"
+	// Attributes of the device
+)
+
+var (
+	ErrAttributeNameRequired = errors.New("attribute name required")
+)
+
+// OTelAttributeOptions configures an attribute sourced from a parameter of the same report, from a query parameter of the request of the device, or from a static value. Value is the fallback when the parameter or the query parameter is missing.
+type OTelAttributeOptions struct {
+	Name string `json:"Name"`
+	// ParameterName is a parameter of the same report, for example Device.DeviceInfo.SoftwareVersion.
+	ParameterName string `json:"ParameterName"`
+	// QueryParameter is a query parameter of the request of the device.
+	QueryParameter string `json:"QueryParameter"`
+	Value          string `json:"Value"`
+}
+
+func validateOTelAttributes(attributesOptions []*OTelAttributeOptions) error {
+	for _, attributeOptions := range attributesOptions {
+		if attributeOptions.Name == "" {
+			return ErrAttributeNameRequired
+		}
+	}
+
+	return nil
+}
+
+// otelAttributes returns the configured attributes for the report. The attributes without a value are omitted.
+func otelAttributes(ctx context.Context, report *otelReportModel, attributesOptions []*OTelAttributeOptions) []attribute.KeyValue {
+	attributes := make([]attribute.KeyValue, 0, len(attributesOptions))
+
+	for _, attributeOptions := range attributesOptions {
+		if value, ok := otelAttributeValue(ctx, report, attributeOptions); ok {
+			attributes = append(attributes, attribute.String(attributeOptions.Name, value))
+		}
+	}
+
+	return attributes
+}
+
+func otelAttributeValue(ctx context.Context, report *otelReportModel, attributeOptions *OTelAttributeOptions) (string, bool) {
+	if attributeOptions.ParameterName != "" {
+		if value, ok := report.parameters[attributeOptions.ParameterName]; ok {
+			return fmt.Sprint(value), true
+		}
+	}
+
+	if attributeOptions.QueryParameter != "" {
+		if value := services.QueryParameters(ctx).Get(attributeOptions.QueryParameter); value != "" {
+			return value, true
+		}
+	}
+
+	return attributeOptions.Value, attributeOptions.Value != ""
+}
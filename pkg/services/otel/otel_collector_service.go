@@ -2,14 +2,24 @@ package otel
 
 import (
 	"context"
+	"maps"
+	"slices"
 	"time"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 
 	"github.com/zdrgeo/bulk-data-collector/pkg/services"
 )
 
+const (
+	DefaultUptimeParameterName = "Device.DeviceInfo.UpTime"
+
+	// Meter of the metrics of the collector itself, apart from the configured meter of the device metrics
+	meterName = "collector"
+)
+
 type OTelMeterOptions struct {
 	Name        string
 	Instruments []*OTelInstrumentOptions
@@ -17,74 +27,233 @@ type OTelMeterOptions struct {
 
 type OTelCollectorServiceOptions struct {
 	Meter *OTelMeterOptions
+	// UptimeParameterName is the parameter whose decrease tells that the device rebooted and reset its cumulative counters. Defaults to DefaultUptimeParameterName.
+	UptimeParameterName string
+	// CounterStateTTL is how long the last values of the cumulative counters of a series are kept after its last report. Defaults to 24 hours.
+	CounterStateTTL time.Duration
+	// DataPoints exports the data points directly, at the collection time of the device, instead of through the instruments. Nil disables it.
+	DataPoints *OTelDataPointsOptions
+	// Attributes are added to the attributes of the device for all instruments.
+	Attributes []*OTelAttributeOptions
+	// DropSerialNumber drops the SerialNumber attribute of all instruments.
+	DropSerialNumber bool
+	// MaxSeries is the number of series of each instrument, beyond which the measurements of new series overflow. Zero is unlimited.
+	MaxSeries int
+	// Overflow is the kind of the overflow series, OTelOverflowKindAttribute or OTelOverflowKindProductClass. Defaults to OTelOverflowKindAttribute. It does not change the series within the limit, which DropSerialNumber aggregates per OUI and ProductClass.
+	Overflow string
+	// SeriesTTL is how long a series counts towards the series limit after its last measurement. Defaults to 24 hours.
+	SeriesTTL time.Duration
+}
+
+// otelInstrumentMapping maps a parameter name, or a pattern of parameter names, to an instrument.
+type otelInstrumentMapping struct {
+	options         *OTelInstrumentOptions
+	instrument      OTelInstrument
+	pattern         *otelParameterPattern
+	rateOptions     *OTelInstrumentOptions
+	rate            metric.Float64Gauge
+	attributeFilter attribute.Filter
+	maxSeries       int
+	overflow        string
+}
+
+// otelReportModel is a report of a device in any of the report formats.
+type otelReportModel struct {
+	collectionTime time.Time
+	parameters     map[string]any
+	parameterTypes map[string]string
 }
 
 type OTelCollectorService struct {
-	instruments map[string]OTelInstrument
-	options     *OTelCollectorServiceOptions
+	instruments           map[string]*otelInstrumentMapping
+	patternInstruments    []*otelInstrumentMapping
+	counterStates         *otelCounterStates
+	seriesLimits          *otelSeriesLimits
+	seriesOverflowCounter metric.Int64Counter
+	dataPoints            *otelDataPoints
+	options               *OTelCollectorServiceOptions
 }
 
 var _ services.CollectorService = (*OTelCollectorService)(nil)
 
 func NewOTelCollectorService(options *OTelCollectorServiceOptions) (*OTelCollectorService, error) {
+	if err := validateOTelAttributes(options.Attributes); err != nil {
+		return nil, err
+	}
+
 	meter := otel.Meter(options.Meter.Name)
 
-	instruments := make(map[string]OTelInstrument, len(options.Meter.Instruments))
+	instruments := make(map[string]*otelInstrumentMapping, len(options.Meter.Instruments))
+	patternInstruments := []*otelInstrumentMapping{}
 
 	for _, instrumentOptions := range options.Meter.Instruments {
+		if err := validateOTelAttributes(instrumentOptions.Attributes); err != nil {
+			return nil, err
+		}
+
 		instrument, err := NewOTelInstrument(meter, instrumentOptions)
 
 		if err != nil {
 			return nil, err
 		}
 
-		instruments[instrumentOptions.ParameterName] = instrument
-	}
+		instrumentMapping := &otelInstrumentMapping{options: instrumentOptions, instrument: instrument, maxSeries: options.MaxSeries, overflow: options.Overflow}
 
-	return &OTelCollectorService{instruments: instruments, options: options}, nil
-}
+		if len(instrumentOptions.AllowedAttributes) != 0 {
+			instrumentMapping.attributeFilter = allowedOTelAttributesFilter(instrumentOptions.AllowedAttributes)
+		}
 
-func (s *OTelCollectorService) Collect(ctx context.Context, oui, productClass, serialNumber string, data *services.DataModel) error {
-	attributes := attribute.NewSet(attribute.String("OUI", oui), attribute.String("ProductClass", productClass), attribute.String("SerialNumber", serialNumber))
+		if instrumentOptions.MaxSeries > 0 {
+			instrumentMapping.maxSeries = instrumentOptions.MaxSeries
+		}
 
-	for _, report := range data.Reports {
-		for key, value := range report.Parameters {
-			instrument, ok := s.instruments[key]
+		if instrumentOptions.Overflow != "" {
+			instrumentMapping.overflow = instrumentOptions.Overflow
+		}
+
+		switch instrumentMapping.overflow {
+		case "", OTelOverflowKindAttribute, OTelOverflowKindProductClass:
+		default:
+			return nil, ErrInvalidOverflowKind
+		}
+
+		if instrumentOptions.Cumulative {
+			if instrumentOptions.Kind != OTelInstrumentKindInt64Counter && instrumentOptions.Kind != OTelInstrumentKindFloat64Counter {
+				return nil, ErrInvalidCumulativeKind
+			}
+
+			if instrumentOptions.RateName != "" {
+				instrumentMapping.rateOptions = &OTelInstrumentOptions{
+					Name:        instrumentOptions.RateName,
+					Kind:        OTelInstrumentKindFloat64Gauge,
+					Description: instrumentOptions.Description,
+					Unit:        instrumentOptions.Unit + "/s",
+				}
 
-			if ok {
-				if err := instrument.Measure(ctx, value, attributes); err != nil {
-					return err
+				if instrumentMapping.rate, err = meter.Float64Gauge(instrumentMapping.rateOptions.Name, metric.WithDescription(instrumentMapping.rateOptions.Description), metric.WithUnit(instrumentMapping.rateOptions.Unit)); err != nil {
+					return nil, err
 				}
 			}
 		}
+
+		if isOTelParameterPattern(instrumentOptions) {
+			if instrumentMapping.pattern, err = newOTelParameterPattern(instrumentOptions); err != nil {
+				return nil, err
+			}
+
+			patternInstruments = append(patternInstruments, instrumentMapping)
+		} else {
+			instruments[instrumentOptions.ParameterName] = instrumentMapping
+		}
 	}
 
-	return nil
+	counterStateTTL := 24 * time.Hour
+
+	if options.CounterStateTTL > 0 {
+		counterStateTTL = options.CounterStateTTL
+	}
+
+	seriesTTL := 24 * time.Hour
+
+	if options.SeriesTTL > 0 {
+		seriesTTL = options.SeriesTTL
+	}
+
+	seriesOverflowCounter, err := otel.Meter(meterName).Int64Counter("series_overflow_counter", metric.WithDescription("Series overflow counter"), metric.WithUnit("count"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var dataPoints *otelDataPoints
+
+	if options.DataPoints != nil {
+		if dataPoints, err = newOTelDataPoints(options.Meter.Name, options.DataPoints); err != nil {
+			return nil, err
+		}
+	}
+
+	return &OTelCollectorService{
+		instruments:           instruments,
+		patternInstruments:    patternInstruments,
+		counterStates:         newOTelCounterStates(counterStateTTL),
+		seriesLimits:          newOTelSeriesLimits(seriesTTL),
+		seriesOverflowCounter: seriesOverflowCounter,
+		dataPoints:            dataPoints,
+		options:               options,
+	}, nil
+}
+
+// Run exports the buffered data points periodically, until the context is done. Without DataPoints, it returns immediately.
+func (s *OTelCollectorService) Run(ctx context.Context) error {
+	if s.dataPoints == nil {
+		return nil
+	}
+
+	return s.dataPoints.run(ctx)
+}
+
+func (s *OTelCollectorService) Collect(ctx context.Context, oui, productClass, serialNumber string, data *services.DataModel) error {
+	reports := make([]*otelReportModel, 0, len(data.Reports))
+
+	for _, report := range data.Reports {
+		reports = append(reports, &otelReportModel{collectionTime: report.CollectionTime, parameters: report.Parameters})
+	}
+
+	return s.collect(ctx, oui, productClass, serialNumber, reports)
 }
 
 func (s *OTelCollectorService) CollectCSV(ctx context.Context, oui, productClass, serialNumber string, bulkData *services.CSVBulkDataModel) error {
-	reports := map[time.Time][]*services.ParameterPerRowModel{}
+	reports := map[time.Time]*otelReportModel{}
 
 	for _, parameterPerRow := range bulkData.ParameterPerRow {
-		reports[parameterPerRow.ReportTimestamp] = append(reports[parameterPerRow.ReportTimestamp], parameterPerRow)
+		value, err := services.ParseParameterValue(parameterPerRow.ParameterType, parameterPerRow.ParameterValue)
+
+		if err != nil {
+			return err
+		}
+
+		report, ok := reports[parameterPerRow.ReportTimestamp]
+
+		if !ok {
+			report = &otelReportModel{collectionTime: parameterPerRow.ReportTimestamp, parameters: map[string]any{}, parameterTypes: map[string]string{}}
+
+			reports[parameterPerRow.ReportTimestamp] = report
+		}
+
+		report.parameters[parameterPerRow.ParameterName] = value
+		report.parameterTypes[parameterPerRow.ParameterName] = parameterPerRow.ParameterType
 	}
 
-	for _, report := range reports {
-		attributes := attribute.NewSet(attribute.String("OUI", oui), attribute.String("ProductClass", productClass), attribute.String("SerialNumber", serialNumber))
+	return s.collect(ctx, oui, productClass, serialNumber, slices.Collect(maps.Values(reports)))
+}
 
-		for _, parameterPerRow := range report {
-			value, err := services.ParseParameterValue(parameterPerRow.ParameterType, parameterPerRow.ParameterValue)
+func (s *OTelCollectorService) CollectJSON(ctx context.Context, oui, productClass, serialNumber string, bulkData *services.JSONBulkDataModel) error {
+	reports := []*otelReportModel{}
 
-			if err != nil {
-				return err
-			}
+	if bulkData.NameValuePair != nil {
+		for _, report := range bulkData.NameValuePair.Report {
+			reports = append(reports, &otelReportModel{collectionTime: collectionTime(report["CollectionTime"]), parameters: report})
+		}
+	}
 
-			instrument, ok := s.instruments[parameterPerRow.ParameterName]
+	return s.collect(ctx, oui, productClass, serialNumber, reports)
+}
 
-			if ok {
-				if err := instrument.Measure(ctx, value, attributes); err != nil {
-					return err
-				}
+// collect measures the parameters of the reports of a device, oldest first, so the cumulative counters are converted in order.
+func (s *OTelCollectorService) collect(ctx context.Context, oui, productClass, serialNumber string, reports []*otelReportModel) error {
+	deviceAttributes := []attribute.KeyValue{AttributeKey_OUI.String(oui), AttributeKey_ProductClass.String(productClass), AttributeKey_SerialNumber.String(serialNumber)}
+
+	slices.SortFunc(reports, func(a, b *otelReportModel) int {
+		return a.collectionTime.Compare(b.collectionTime)
+	})
+
+	for _, report := range reports {
+		reportAttributes := append(slices.Clone(deviceAttributes), otelAttributes(ctx, report, s.options.Attributes)...)
+
+		for parameterName, value := range report.parameters {
+			if err := s.measure(ctx, report, parameterName, value, reportAttributes); err != nil {
+				return err
 			}
 		}
 	}
@@ -92,22 +261,176 @@ func (s *OTelCollectorService) CollectCSV(ctx context.Context, oui, productClass
 	return nil
 }
 
-func (s *OTelCollectorService) CollectJSON(ctx context.Context, oui, productClass, serialNumber string, bulkData *services.JSONBulkDataModel) error {
-	attributes := attribute.NewSet(attribute.String("OUI", oui), attribute.String("ProductClass", productClass), attribute.String("SerialNumber", serialNumber))
+// measure measures the value with the instrument of the parameter. The exact parameter names take precedence over the patterns, which are matched in order and add the instance attributes.
+func (s *OTelCollectorService) measure(ctx context.Context, report *otelReportModel, parameterName string, value any, reportAttributes []attribute.KeyValue) error {
+	var instanceAttributes []attribute.KeyValue
 
-	if bulkData.NameValuePair != nil {
-		for _, report := range bulkData.NameValuePair.Report {
-			for key, value := range report {
-				instrument, ok := s.instruments[key]
+	instrumentMapping, ok := s.instruments[parameterName]
 
-				if ok {
-					if err := instrument.Measure(ctx, value, attributes); err != nil {
-						return err
-					}
-				}
+	if !ok {
+		for _, patternInstrument := range s.patternInstruments {
+			if instanceAttributes, ok = patternInstrument.pattern.match(parameterName); ok {
+				instrumentMapping = patternInstrument
+
+				break
 			}
 		}
 	}
 
+	if instrumentMapping == nil {
+		return nil
+	}
+
+	seriesAttributes, attributes := s.attributes(ctx, instrumentMapping, report, reportAttributes, instanceAttributes)
+
+	if instrumentMapping.options.Cumulative {
+		delta, err := s.cumulativeDelta(instrumentMapping, report, parameterName, value, seriesAttributes)
+
+		if err != nil || delta == nil {
+			return err
+		}
+
+		return s.record(ctx, instrumentMapping, attributes, delta.startTime, report.collectionTime, delta.value, delta.rate)
+	}
+
+	return s.record(ctx, instrumentMapping, attributes, report.collectionTime, report.collectionTime, value, -1)
+}
+
+// attributes returns the attributes of the series, which always identify the device, and the attributes of the measurement, which may not.
+func (s *OTelCollectorService) attributes(ctx context.Context, instrumentMapping *otelInstrumentMapping, report *otelReportModel, reportAttributes, instanceAttributes []attribute.KeyValue) (attribute.Set, attribute.Set) {
+	attributes := slices.Concat(reportAttributes, instanceAttributes, otelAttributes(ctx, report, instrumentMapping.options.Attributes))
+
+	seriesAttributes := attribute.NewSet(attributes...)
+
+	if !s.options.DropSerialNumber && !instrumentMapping.options.DropSerialNumber {
+		return seriesAttributes, seriesAttributes
+	}
+
+	measurementAttributes, _ := attribute.NewSetWithFiltered(attributes, func(keyValue attribute.KeyValue) bool {
+		return keyValue.Key != AttributeKey_SerialNumber
+	})
+
+	return seriesAttributes, measurementAttributes
+}
+
+// cumulativeDelta converts the cumulative value of the device into the delta since the previous report of the series.
+func (s *OTelCollectorService) cumulativeDelta(instrumentMapping *otelInstrumentMapping, report *otelReportModel, parameterName string, value any, attributes attribute.Set) (*otelCounterDelta, error) {
+	uptimeParameterName := DefaultUptimeParameterName
+
+	if s.options.UptimeParameterName != "" {
+		uptimeParameterName = s.options.UptimeParameterName
+	}
+
+	uptime := int64(-1)
+
+	if uptimeValue, ok := report.parameters[uptimeParameterName]; ok {
+		if int64Value, err := toInt64(uptimeValue); err == nil {
+			uptime = int64Value
+		}
+	}
+
+	wrapBits := instrumentMapping.options.WrapBits
+
+	if wrapBits == 0 {
+		switch report.parameterTypes[parameterName] {
+		case services.ParameterType_unsignedInt:
+			wrapBits = 32
+		case services.ParameterType_unsignedLong:
+			wrapBits = 64
+		}
+	}
+
+	sample := &otelCounterSample{collectionTime: report.collectionTime, uptime: uptime, wrapBits: wrapBits}
+
+	if instrumentMapping.options.Kind == OTelInstrumentKindFloat64Counter {
+		float64Value, err := toFloat64(value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sample.float = true
+		sample.float64Value = float64Value
+	} else {
+		uint64Value, err := toUint64(value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sample.uint64Value = uint64Value
+	}
+
+	return s.counterStates.update(otelSeriesKey{name: instrumentMapping.options.Name, attributes: attributes.Equivalent()}, sample), nil
+}
+
+// record records the value, and optionally the rate per second, either with the instruments or, when the data points are exported directly, as data points at the collection time of the device.
+func (s *OTelCollectorService) record(ctx context.Context, instrumentMapping *otelInstrumentMapping, attributes attribute.Set, startTime, collectionTime time.Time, value any, rate float64) error {
+	attributes = s.limitAttributes(ctx, instrumentMapping, attributes)
+
+	if s.dataPoints != nil {
+		// The views do not apply to the data points
+		if instrumentMapping.attributeFilter != nil {
+			attributes, _ = attributes.Filter(instrumentMapping.attributeFilter)
+		}
+
+		if err := s.dataPoints.add(instrumentMapping.options, attributes, startTime, collectionTime, value); err != nil {
+			return err
+		}
+
+		if instrumentMapping.rateOptions != nil && rate >= 0 {
+			return s.dataPoints.add(instrumentMapping.rateOptions, attributes, collectionTime, collectionTime, rate)
+		}
+
+		return nil
+	}
+
+	if err := instrumentMapping.instrument.Measure(ctx, value, attributes); err != nil {
+		return err
+	}
+
+	if instrumentMapping.rate != nil && rate >= 0 {
+		instrumentMapping.rate.Record(ctx, rate, metric.WithAttributeSet(attributes))
+	}
+
 	return nil
 }
+
+// limitAttributes returns the attributes of the overflow series instead of the attributes of a new series beyond the series limit of the instrument. The series are counted after the allowed attributes filter, like the views see them.
+func (s *OTelCollectorService) limitAttributes(ctx context.Context, instrumentMapping *otelInstrumentMapping, attributes attribute.Set) attribute.Set {
+	if instrumentMapping.maxSeries <= 0 {
+		return attributes
+	}
+
+	seriesAttributes := attributes
+
+	if instrumentMapping.attributeFilter != nil {
+		seriesAttributes, _ = attributes.Filter(instrumentMapping.attributeFilter)
+	}
+
+	allowed, overflowed := s.seriesLimits.allow(otelSeriesKey{name: instrumentMapping.options.Name, attributes: seriesAttributes.Equivalent()}, instrumentMapping.maxSeries)
+
+	if allowed {
+		return attributes
+	}
+
+	// The counter counts the distinct series beyond the limit, rather than their measurements
+	if overflowed {
+		s.seriesOverflowCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("instrument", instrumentMapping.options.Name)))
+	}
+
+	return overflowOTelAttributes(instrumentMapping.overflow, attributes)
+}
+
+// collectionTime converts the CollectionTime of the NameValuePair report format, in seconds since the Unix epoch.
+func collectionTime(value any) time.Time {
+	if timeValue, ok := value.(time.Time); ok {
+		return timeValue
+	}
+
+	if int64Value, err := toInt64(value); err == nil {
+		return time.Unix(int64Value, 0)
+	}
+
+	return time.Time{}
+}
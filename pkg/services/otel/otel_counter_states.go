@@ -0,0 +1,143 @@
+package otel
+
+import (
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// otelSeriesKey identifies a series of an instrument, that is the device and the instance attributes.
+type otelSeriesKey struct {
+	name       string
+	attributes attribute.Distinct
+}
+
+// otelCounterSample is a value of a cumulative counter of a device, either uint64Value or, for the float counters, float64Value. The uptime is -1 when the report has no uptime.
+type otelCounterSample struct {
+	collectionTime time.Time
+	uptime         int64
+	wrapBits       int
+	float          bool
+	uint64Value    uint64
+	float64Value   float64
+}
+
+// otelCounterDelta is the increase of a cumulative counter since the previous sample at the start time, as uint64 or float64 like the sample. The rate per second is -1 when unknown.
+type otelCounterDelta struct {
+	value     any
+	rate      float64
+	startTime time.Time
+}
+
+type otelCounterState struct {
+	sample     *otelCounterSample
+	updateTime time.Time
+}
+
+// otelCounterStates keeps the last value of each series of the cumulative counters, to convert the values into deltas.
+type otelCounterStates struct {
+	mutex     sync.Mutex
+	states    map[otelSeriesKey]*otelCounterState
+	ttl       time.Duration
+	pruneTime time.Time
+}
+
+func newOTelCounterStates(ttl time.Duration) *otelCounterStates {
+	return &otelCounterStates{states: map[otelSeriesKey]*otelCounterState{}, ttl: ttl, pruneTime: time.Now()}
+}
+
+// update returns the delta since the previous sample of the series. The first sample of a series, and samples older than the previous one, have no delta.
+//
+// A decrease of the value is a reset of the counter when the uptime decreased as well, because the device rebooted, or when the wrap bits are not known. Otherwise it is a wrap-around if the previous value was in the upper half of the range of the counter.
+func (s *otelCounterStates) update(key otelSeriesKey, sample *otelCounterSample) *otelCounterDelta {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+
+	s.prune(now)
+
+	state, ok := s.states[key]
+
+	if !ok {
+		s.states[key] = &otelCounterState{sample: sample, updateTime: now}
+
+		return nil
+	}
+
+	previous := state.sample
+
+	if !sample.collectionTime.After(previous.collectionTime) {
+		return nil
+	}
+
+	state.sample = sample
+	state.updateTime = now
+
+	rebooted := sample.uptime >= 0 && previous.uptime >= 0 && sample.uptime < previous.uptime
+
+	var (
+		delta      any
+		deltaValue float64
+	)
+
+	if sample.float {
+		float64Delta := sample.float64Value
+
+		if !rebooted {
+			float64Delta = counterDelta(previous.float64Value, sample.float64Value, sample.wrapBits)
+		}
+
+		delta, deltaValue = float64Delta, float64Delta
+	} else {
+		uint64Delta := sample.uint64Value
+
+		if !rebooted {
+			uint64Delta = counterDelta(previous.uint64Value, sample.uint64Value, sample.wrapBits)
+		}
+
+		delta, deltaValue = uint64Delta, float64(uint64Delta)
+	}
+
+	rate := -1.0
+
+	if seconds := sample.collectionTime.Sub(previous.collectionTime).Seconds(); seconds > 0 {
+		rate = deltaValue / seconds
+	}
+
+	return &otelCounterDelta{value: delta, rate: rate, startTime: previous.collectionTime}
+}
+
+// prune forgets the series without reports for longer than the TTL. It scans the series at most once per half TTL.
+func (s *otelCounterStates) prune(now time.Time) {
+	if now.Sub(s.pruneTime) < s.ttl/2 {
+		return
+	}
+
+	s.pruneTime = now
+
+	for key, state := range s.states {
+		if now.Sub(state.updateTime) > s.ttl {
+			delete(s.states, key)
+		}
+	}
+}
+
+// counterDelta returns the increase of a counter from the previous to the current value, assuming a wrap-around of a counter of wrapBits bits or else a reset to zero when the value decreased.
+func counterDelta[T uint64 | float64](previous, current T, wrapBits int) T {
+	if current >= previous {
+		return current - previous
+	}
+
+	if wrapBits > 0 && wrapBits <= 64 {
+		half := T(uint64(1) << (wrapBits - 1))
+
+		if previous >= half {
+			// 2^wrapBits - previous + current, without overflowing the range of uint64
+			return (half - previous) + half + current
+		}
+	}
+
+	return current
+}
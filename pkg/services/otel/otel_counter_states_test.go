@@ -0,0 +1,162 @@
+package otel
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func newTestCounterSample(seconds int64, uptime int64, wrapBits int, value uint64) *otelCounterSample {
+	return &otelCounterSample{collectionTime: time.Unix(seconds, 0), uptime: uptime, wrapBits: wrapBits, uint64Value: value}
+}
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous uint64
+		current  uint64
+		wrapBits int
+		want     uint64
+	}{
+		{name: "increase", previous: 100, current: 150, wrapBits: 32, want: 50},
+		{name: "unchanged", previous: 100, current: 100, wrapBits: 32, want: 0},
+		{name: "32-bit wrap-around", previous: math.MaxUint32 - 9, current: 5, wrapBits: 32, want: 15},
+		{name: "64-bit wrap-around", previous: math.MaxUint64 - 9, current: 5, wrapBits: 64, want: 15},
+		{name: "reset in the lower half", previous: 100, current: 5, wrapBits: 32, want: 5},
+		{name: "reset with unknown wrap bits", previous: math.MaxUint32 - 9, current: 5, wrapBits: 0, want: 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if delta := counterDelta(test.previous, test.current, test.wrapBits); delta != test.want {
+				t.Errorf("delta is %d, want %d", delta, test.want)
+			}
+		})
+	}
+}
+
+func TestCounterStatesUpdate(t *testing.T) {
+	tests := []struct {
+		name      string
+		samples   []*otelCounterSample
+		wantDelta any
+		wantRate  float64
+		wantStart time.Time
+	}{
+		{
+			name:    "first sample",
+			samples: []*otelCounterSample{newTestCounterSample(0, -1, 32, 100)},
+		},
+		{
+			name:      "increase",
+			samples:   []*otelCounterSample{newTestCounterSample(0, -1, 32, 100), newTestCounterSample(10, -1, 32, 150)},
+			wantDelta: uint64(50),
+			wantRate:  5,
+			wantStart: time.Unix(0, 0),
+		},
+		{
+			name:    "out of order sample",
+			samples: []*otelCounterSample{newTestCounterSample(10, -1, 32, 150), newTestCounterSample(0, -1, 32, 100)},
+		},
+		{
+			name:    "sample at the same time",
+			samples: []*otelCounterSample{newTestCounterSample(10, -1, 32, 150), newTestCounterSample(10, -1, 32, 200)},
+		},
+		{
+			name:      "reboot detected through uptime",
+			samples:   []*otelCounterSample{newTestCounterSample(0, 1000, 32, math.MaxUint32-9), newTestCounterSample(10, 5, 32, 20)},
+			wantDelta: uint64(20),
+			wantRate:  2,
+			wantStart: time.Unix(0, 0),
+		},
+		{
+			name:      "32-bit wrap-around",
+			samples:   []*otelCounterSample{newTestCounterSample(0, 1000, 32, math.MaxUint32-9), newTestCounterSample(10, 1010, 32, 10)},
+			wantDelta: uint64(20),
+			wantRate:  2,
+			wantStart: time.Unix(0, 0),
+		},
+		{
+			name:      "64-bit wrap-around",
+			samples:   []*otelCounterSample{newTestCounterSample(0, -1, 64, math.MaxUint64-9), newTestCounterSample(10, -1, 64, 10)},
+			wantDelta: uint64(20),
+			wantRate:  2,
+			wantStart: time.Unix(0, 0),
+		},
+		{
+			name:      "reset with unknown wrap bits",
+			samples:   []*otelCounterSample{newTestCounterSample(0, -1, 0, math.MaxUint32-9), newTestCounterSample(10, -1, 0, 10)},
+			wantDelta: uint64(10),
+			wantRate:  1,
+			wantStart: time.Unix(0, 0),
+		},
+		{
+			name: "float counter",
+			samples: []*otelCounterSample{
+				{collectionTime: time.Unix(0, 0), uptime: -1, float: true, float64Value: 1.5},
+				{collectionTime: time.Unix(4, 0), uptime: -1, float: true, float64Value: 3.5},
+			},
+			wantDelta: 2.0,
+			wantRate:  0.5,
+			wantStart: time.Unix(0, 0),
+		},
+	}
+
+	attributes := attribute.NewSet(attribute.String("SerialNumber", "SerialNumber"))
+
+	key := otelSeriesKey{name: "Counter", attributes: attributes.Equivalent()}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counterStates := newOTelCounterStates(time.Hour)
+
+			var delta *otelCounterDelta
+
+			for _, sample := range test.samples {
+				delta = counterStates.update(key, sample)
+			}
+
+			if test.wantDelta == nil {
+				if delta != nil {
+					t.Errorf("delta is %v, want none", delta.value)
+				}
+
+				return
+			}
+
+			if delta == nil {
+				t.Fatalf("delta is none, want %v", test.wantDelta)
+			}
+
+			if delta.value != test.wantDelta {
+				t.Errorf("delta is %v, want %v", delta.value, test.wantDelta)
+			}
+
+			if delta.rate != test.wantRate {
+				t.Errorf("rate is %v, want %v", delta.rate, test.wantRate)
+			}
+
+			if !delta.startTime.Equal(test.wantStart) {
+				t.Errorf("start time is %v, want %v", delta.startTime, test.wantStart)
+			}
+		})
+	}
+}
+
+func TestCounterStatesPrune(t *testing.T) {
+	counterStates := newOTelCounterStates(time.Hour)
+
+	key := otelSeriesKey{name: "Counter"}
+
+	counterStates.update(key, newTestCounterSample(0, -1, 32, 100))
+
+	// The series was updated longer than the TTL ago, and the last prune was longer than half the TTL ago
+	counterStates.states[key].updateTime = time.Now().Add(-2 * time.Hour)
+	counterStates.pruneTime = time.Now().Add(-time.Hour)
+
+	if delta := counterStates.update(key, newTestCounterSample(10, -1, 32, 150)); delta != nil {
+		t.Errorf("delta of the forgotten series is %v, want none", delta.value)
+	}
+}
@@ -0,0 +1,344 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+var (
+	ErrExporterRequired = errors.New("exporter required")
+)
+
+var (
+	// Default explicit bucket boundaries of the OTel SDK
+	defaultHistogramBounds = []float64{0, 5, 10, 25, 50, 75, 100, 250, 500, 750, 1000, 2500, 5000, 7500, 10000}
+)
+
+type OTelDataPointsOptions struct {
+	// Exporter is the configured exporter, for example the OTLP exporter.
+	Exporter sdkmetric.Exporter
+	Resource *resource.Resource
+	// ExportInterval defaults to 10 seconds.
+	ExportInterval time.Duration
+	// MaxDataPoints is the number of buffered data points, beyond which the oldest are dropped. Defaults to 100000.
+	MaxDataPoints int
+	Logger        *slog.Logger
+}
+
+// otelDataPoint is a measurement of the device, at the collection time of the device instead of the time of the export.
+type otelDataPoint struct {
+	options      *OTelInstrumentOptions
+	attributes   attribute.Set
+	startTime    time.Time
+	time         time.Time
+	int64Value   int64
+	float64Value float64
+}
+
+// otelDataPointKey identifies the data points of an instrument that are merged into one, because the backends reject the data points with the same attributes and time.
+type otelDataPointKey struct {
+	attributes attribute.Distinct
+	time       int64
+}
+
+// otelDataPoints buffers the data points and exports them periodically.
+type otelDataPoints struct {
+	scope          instrumentation.Scope
+	options        *OTelDataPointsOptions
+	logger         *slog.Logger
+	mutex          sync.Mutex
+	dataPoints     []*otelDataPoint
+	maxDataPoints  int
+	droppedCounter metric.Int64Counter
+}
+
+func newOTelDataPoints(scopeName string, options *OTelDataPointsOptions) (*otelDataPoints, error) {
+	if options.Exporter == nil {
+		return nil, ErrExporterRequired
+	}
+
+	logger := options.Logger
+
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	maxDataPoints := 100_000
+
+	if options.MaxDataPoints > 0 {
+		maxDataPoints = options.MaxDataPoints
+	}
+
+	droppedCounter, err := otel.Meter(meterName).Int64Counter("data_point_dropped_counter", metric.WithDescription("Data point dropped counter"), metric.WithUnit("count"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &otelDataPoints{
+		scope:          instrumentation.Scope{Name: scopeName},
+		options:        options,
+		logger:         logger,
+		maxDataPoints:  maxDataPoints,
+		droppedCounter: droppedCounter,
+	}, nil
+}
+
+// add buffers the value as a data point of the kind of the instrument.
+func (p *otelDataPoints) add(options *OTelInstrumentOptions, attributes attribute.Set, startTime, collectionTime time.Time, value any) error {
+	dataPoint := &otelDataPoint{options: options, attributes: attributes, startTime: startTime, time: collectionTime}
+
+	switch options.Kind {
+	case OTelInstrumentKindInt64Counter, OTelInstrumentKindInt64Gauge, OTelInstrumentKindInt64Histogram, OTelInstrumentKindInt64UpDownCounter:
+		int64Value, err := toInt64(value)
+
+		if err != nil {
+			return err
+		}
+
+		dataPoint.int64Value = int64Value
+	default:
+		float64Value, err := toFloat64(value)
+
+		if err != nil {
+			return err
+		}
+
+		dataPoint.float64Value = float64Value
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.dataPoints = append(p.dataPoints, dataPoint)
+
+	p.truncate()
+
+	return nil
+}
+
+func (p *otelDataPoints) run(ctx context.Context) error {
+	exportInterval := 10 * time.Second
+
+	if p.options.ExportInterval > 0 {
+		exportInterval = p.options.ExportInterval
+	}
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Export the remaining data points, unless the export fails
+			p.export(context.WithoutCancel(ctx))
+
+			return nil
+		case <-ticker.C:
+			p.export(ctx)
+		}
+	}
+}
+
+// export exports the buffered data points. When the export fails, the data points are buffered again for the next export.
+func (p *otelDataPoints) export(ctx context.Context) {
+	p.mutex.Lock()
+	dataPoints := p.dataPoints
+	p.dataPoints = nil
+	p.mutex.Unlock()
+
+	if len(dataPoints) == 0 {
+		return
+	}
+
+	resourceMetrics := &metricdata.ResourceMetrics{
+		Resource: p.options.Resource,
+		ScopeMetrics: []metricdata.ScopeMetrics{
+			{Scope: p.scope, Metrics: p.newMetrics(dataPoints)},
+		},
+	}
+
+	if err := p.options.Exporter.Export(ctx, resourceMetrics); err != nil {
+		p.logger.Warn("Data points export failed", "count", len(dataPoints), "error", err)
+
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+
+		p.dataPoints = append(dataPoints, p.dataPoints...)
+
+		p.truncate()
+	}
+}
+
+// truncate drops the oldest data points beyond the maximum.
+func (p *otelDataPoints) truncate() {
+	if dropCount := len(p.dataPoints) - p.maxDataPoints; dropCount > 0 {
+		p.dataPoints = slices.Delete(p.dataPoints, 0, dropCount)
+
+		p.droppedCounter.Add(context.Background(), int64(dropCount))
+	}
+}
+
+// newMetrics groups the data points by instrument into metrics. The data points of an instrument with the same attributes and time, for example of the devices in an overflow series or without the SerialNumber attribute, are merged.
+func (p *otelDataPoints) newMetrics(dataPoints []*otelDataPoint) []metricdata.Metrics {
+	instrumentsOptions := []*OTelInstrumentOptions{}
+	instrumentsDataPoints := map[*OTelInstrumentOptions][]*otelDataPoint{}
+
+	for _, dataPoint := range dataPoints {
+		if _, ok := instrumentsDataPoints[dataPoint.options]; !ok {
+			instrumentsOptions = append(instrumentsOptions, dataPoint.options)
+		}
+
+		instrumentsDataPoints[dataPoint.options] = append(instrumentsDataPoints[dataPoint.options], dataPoint)
+	}
+
+	metrics := make([]metricdata.Metrics, 0, len(instrumentsOptions))
+
+	for _, instrumentOptions := range instrumentsOptions {
+		instrumentDataPoints := groupDataPoints(instrumentsDataPoints[instrumentOptions])
+
+		var data metricdata.Aggregation
+
+		switch instrumentOptions.Kind {
+		case OTelInstrumentKindInt64Counter:
+			data = newSum(instrumentDataPoints, true, int64Value)
+		case OTelInstrumentKindInt64Gauge:
+			data = newGauge(instrumentDataPoints, int64Value)
+		case OTelInstrumentKindInt64Histogram:
+			data = newHistogram(instrumentDataPoints, int64Value)
+		case OTelInstrumentKindInt64UpDownCounter:
+			data = newSum(instrumentDataPoints, false, int64Value)
+		case OTelInstrumentKindFloat64Counter:
+			data = newSum(instrumentDataPoints, true, float64Value)
+		case OTelInstrumentKindFloat64Gauge:
+			data = newGauge(instrumentDataPoints, float64Value)
+		case OTelInstrumentKindFloat64Histogram:
+			data = newHistogram(instrumentDataPoints, float64Value)
+		case OTelInstrumentKindFloat64UpDownCounter:
+			data = newSum(instrumentDataPoints, false, float64Value)
+		}
+
+		metrics = append(metrics, metricdata.Metrics{Name: instrumentOptions.Name, Description: instrumentOptions.Description, Unit: instrumentOptions.Unit, Data: data})
+	}
+
+	return metrics
+}
+
+// groupDataPoints groups the data points by attributes and time, in the order of their first data point.
+func groupDataPoints(dataPoints []*otelDataPoint) [][]*otelDataPoint {
+	keys := []otelDataPointKey{}
+	groups := map[otelDataPointKey][]*otelDataPoint{}
+
+	for _, dataPoint := range dataPoints {
+		key := otelDataPointKey{attributes: dataPoint.attributes.Equivalent(), time: dataPoint.time.UnixNano()}
+
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+
+		groups[key] = append(groups[key], dataPoint)
+	}
+
+	dataPointGroups := make([][]*otelDataPoint, 0, len(keys))
+
+	for _, key := range keys {
+		dataPointGroups = append(dataPointGroups, groups[key])
+	}
+
+	return dataPointGroups
+}
+
+func int64Value(dataPoint *otelDataPoint) int64 {
+	return dataPoint.int64Value
+}
+
+func float64Value(dataPoint *otelDataPoint) float64 {
+	return dataPoint.float64Value
+}
+
+// newGauge creates a gauge, whose each data point is the last value of its group.
+func newGauge[N int64 | float64](dataPointGroups [][]*otelDataPoint, value func(*otelDataPoint) N) metricdata.Gauge[N] {
+	gauge := metricdata.Gauge[N]{DataPoints: make([]metricdata.DataPoint[N], 0, len(dataPointGroups))}
+
+	for _, dataPoints := range dataPointGroups {
+		dataPoint := dataPoints[len(dataPoints)-1]
+
+		gauge.DataPoints = append(gauge.DataPoints, metricdata.DataPoint[N]{Attributes: dataPoint.attributes, Time: dataPoint.time, Value: value(dataPoint)})
+	}
+
+	return gauge
+}
+
+// newSum creates a sum with delta temporality, because each data point is the change since its start time. The data point of a group is the total change since the earliest start time.
+func newSum[N int64 | float64](dataPointGroups [][]*otelDataPoint, isMonotonic bool, value func(*otelDataPoint) N) metricdata.Sum[N] {
+	sum := metricdata.Sum[N]{DataPoints: make([]metricdata.DataPoint[N], 0, len(dataPointGroups)), Temporality: metricdata.DeltaTemporality, IsMonotonic: isMonotonic}
+
+	for _, dataPoints := range dataPointGroups {
+		sumDataPoint := metricdata.DataPoint[N]{Attributes: dataPoints[0].attributes, StartTime: dataPoints[0].startTime, Time: dataPoints[0].time}
+
+		for _, dataPoint := range dataPoints {
+			if dataPoint.startTime.Before(sumDataPoint.StartTime) {
+				sumDataPoint.StartTime = dataPoint.startTime
+			}
+
+			sumDataPoint.Value += value(dataPoint)
+		}
+
+		sum.DataPoints = append(sum.DataPoints, sumDataPoint)
+	}
+
+	return sum
+}
+
+// newHistogram creates a histogram with delta temporality, whose each data point has the measurements of its group.
+func newHistogram[N int64 | float64](dataPointGroups [][]*otelDataPoint, value func(*otelDataPoint) N) metricdata.Histogram[N] {
+	histogram := metricdata.Histogram[N]{DataPoints: make([]metricdata.HistogramDataPoint[N], 0, len(dataPointGroups)), Temporality: metricdata.DeltaTemporality}
+
+	for _, dataPoints := range dataPointGroups {
+		histogramDataPoint := metricdata.HistogramDataPoint[N]{
+			Attributes:   dataPoints[0].attributes,
+			StartTime:    dataPoints[0].startTime,
+			Time:         dataPoints[0].time,
+			Bounds:       defaultHistogramBounds,
+			BucketCounts: make([]uint64, len(defaultHistogramBounds)+1),
+		}
+
+		minValue, maxValue := value(dataPoints[0]), value(dataPoints[0])
+
+		for _, dataPoint := range dataPoints {
+			dataPointValue := value(dataPoint)
+
+			if dataPoint.startTime.Before(histogramDataPoint.StartTime) {
+				histogramDataPoint.StartTime = dataPoint.startTime
+			}
+
+			bucketIndex, _ := slices.BinarySearch(defaultHistogramBounds, float64(dataPointValue))
+
+			histogramDataPoint.BucketCounts[bucketIndex]++
+			histogramDataPoint.Count++
+			histogramDataPoint.Sum += dataPointValue
+
+			minValue = min(minValue, dataPointValue)
+			maxValue = max(maxValue, dataPointValue)
+		}
+
+		histogramDataPoint.Min = metricdata.NewExtrema(minValue)
+		histogramDataPoint.Max = metricdata.NewExtrema(maxValue)
+
+		histogram.DataPoints = append(histogram.DataPoints, histogramDataPoint)
+	}
+
+	return histogram
+}
@@ -12,6 +12,7 @@ import (
 var (
 	ErrInvalidInstrumentKind = errors.New("invalid instrument kind")
 	ErrInvalidValueType      = errors.New("invalid value type")
+	ErrInvalidCumulativeKind = errors.New("invalid cumulative instrument kind")
 )
 
 const (
@@ -26,11 +27,32 @@ const (
 )
 
 type OTelInstrumentOptions struct {
+	// ParameterName can contain instance placeholders, for example Device.Ethernet.Interface.{i}.Stats.BytesSent.
 	ParameterName string `json:"ParameterName"`
-	Name          string `json:"Name"`
-	Kind          string `json:"Kind"`
-	Description   string `json:"Description"`
-	Unit          string `json:"Unit"`
+	// ParameterPattern is a regex that matches the parameter names instead of ParameterName.
+	ParameterPattern string `json:"ParameterPattern"`
+	// InstanceAttributes name the attributes of the instance placeholders or of the groups of ParameterPattern, in order.
+	InstanceAttributes []string `json:"InstanceAttributes"`
+	Name               string   `json:"Name"`
+	Kind               string   `json:"Kind"`
+	Description        string   `json:"Description"`
+	Unit               string   `json:"Unit"`
+	// Cumulative converts the cumulative values of the device, for example the bytes sent since boot, into the deltas since the previous report. Only for the counter kinds.
+	Cumulative bool `json:"Cumulative"`
+	// WrapBits is the size of the cumulative counter of the device, 32 or 64, for the detection of its wrap-around. Defaults to the size of the unsignedInt and unsignedLong parameter types.
+	WrapBits int `json:"WrapBits"`
+	// RateName is the name of the optional gauge of the rate per second of the cumulative counter.
+	RateName string `json:"RateName"`
+	// Attributes are added to the attributes of the device, after the global attributes.
+	Attributes []*OTelAttributeOptions `json:"Attributes"`
+	// DropSerialNumber drops the SerialNumber attribute, so the metric is aggregated per OUI and ProductClass.
+	DropSerialNumber bool `json:"DropSerialNumber"`
+	// AllowedAttributes are the only attributes kept by the view of the instrument, if any.
+	AllowedAttributes []string `json:"AllowedAttributes"`
+	// MaxSeries overrides the series limit of the service.
+	MaxSeries int `json:"MaxSeries"`
+	// Overflow overrides the overflow kind of the service.
+	Overflow string `json:"Overflow"`
 }
 
 type OTelInstrument interface {
@@ -94,6 +116,50 @@ func toInt64(value any) (int64, error) {
 	return int64Value, err
 }
 
+func toUint64(value any) (uint64, error) {
+	var (
+		uint64Value uint64
+		err         error
+	)
+
+	switch v := value.(type) {
+	case string:
+		uint64Value, err = strconv.ParseUint(v, 10, 64)
+	case int:
+		if v < 0 {
+			uint64Value, err = 0, ErrInvalidValueType
+		} else {
+			uint64Value, err = uint64(v), nil
+		}
+	case int64:
+		if v < 0 {
+			uint64Value, err = 0, ErrInvalidValueType
+		} else {
+			uint64Value, err = uint64(v), nil
+		}
+	case uint:
+		uint64Value, err = uint64(v), nil
+	case uint64:
+		uint64Value, err = v, nil
+	case float32:
+		if v < 0 {
+			uint64Value, err = 0, ErrInvalidValueType
+		} else {
+			uint64Value, err = uint64(v), nil
+		}
+	case float64:
+		if v < 0 {
+			uint64Value, err = 0, ErrInvalidValueType
+		} else {
+			uint64Value, err = uint64(v), nil
+		}
+	default:
+		uint64Value, err = 0, ErrInvalidValueType
+	}
+
+	return uint64Value, err
+}
+
 func toFloat64(value any) (float64, error) {
 	var (
 		float64Value float64
@@ -0,0 +1,109 @@
+package otel
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// InstancePlaceholder stands for the instance numbers of the multi-instance objects in the parameter names, for example Device.Ethernet.Interface.{i}.Stats.BytesSent.
+	InstancePlaceholder = "{i}"
+)
+
+var (
+	ErrInvalidInstanceAttributes = errors.New("invalid instance attributes")
+)
+
+// otelParameterPattern matches the parameter names of an instrument and extracts the instance numbers, or the named regex groups, as attributes.
+type otelParameterPattern struct {
+	regexp         *regexp.Regexp
+	attributeNames []string
+}
+
+// newOTelParameterPattern compiles either the parameter name with instance placeholders or the regex pattern of the instrument. The attribute of each placeholder is named after the preceding object, for example interface for Interface.{i}, unless the instance attributes name it. The attributes of the regex pattern are named after its named groups, unless the instance attributes name the groups.
+func newOTelParameterPattern(options *OTelInstrumentOptions) (*otelParameterPattern, error) {
+	if options.ParameterPattern != "" {
+		parameterRegexp, err := regexp.Compile("^(?:" + options.ParameterPattern + ")$")
+
+		if err != nil {
+			return nil, err
+		}
+
+		attributeNames := parameterRegexp.SubexpNames()[1:]
+
+		if len(options.InstanceAttributes) != 0 {
+			if len(options.InstanceAttributes) != len(attributeNames) {
+				return nil, ErrInvalidInstanceAttributes
+			}
+
+			attributeNames = options.InstanceAttributes
+		}
+
+		return &otelParameterPattern{regexp: parameterRegexp, attributeNames: attributeNames}, nil
+	}
+
+	segments := strings.Split(options.ParameterName, ".")
+	expression := make([]string, 0, len(segments))
+	attributeNames := []string{}
+
+	for segmentIndex, segment := range segments {
+		if segment != InstancePlaceholder {
+			expression = append(expression, regexp.QuoteMeta(segment))
+
+			continue
+		}
+
+		expression = append(expression, "([0-9]+)")
+
+		attributeName := "instance"
+
+		if segmentIndex > 0 {
+			attributeName = strings.ToLower(segments[segmentIndex-1])
+		}
+
+		attributeNames = append(attributeNames, attributeName)
+	}
+
+	if len(options.InstanceAttributes) != 0 {
+		if len(options.InstanceAttributes) != len(attributeNames) {
+			return nil, ErrInvalidInstanceAttributes
+		}
+
+		attributeNames = options.InstanceAttributes
+	}
+
+	parameterRegexp, err := regexp.Compile("^" + strings.Join(expression, `\.`) + "$")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &otelParameterPattern{regexp: parameterRegexp, attributeNames: attributeNames}, nil
+}
+
+// isOTelParameterPattern reports whether the instrument maps a pattern of parameter names instead of a single parameter name.
+func isOTelParameterPattern(options *OTelInstrumentOptions) bool {
+	return options.ParameterPattern != "" || strings.Contains(options.ParameterName, InstancePlaceholder)
+}
+
+// match returns the attributes extracted from the parameter name, if the parameter name matches the pattern.
+func (p *otelParameterPattern) match(parameterName string) ([]attribute.KeyValue, bool) {
+	submatches := p.regexp.FindStringSubmatch(parameterName)
+
+	if submatches == nil {
+		return nil, false
+	}
+
+	attributes := make([]attribute.KeyValue, 0, len(p.attributeNames))
+
+	for attributeIndex, attributeName := range p.attributeNames {
+		if attributeName != "" {
+			attributes = append(attributes, attribute.String(attributeName, submatches[attributeIndex+1]))
+		}
+	}
+
+	return attributes, true
+}
@@ -0,0 +1,106 @@
+package otel
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	OTelOverflowKindAttribute    = "Attribute"
+	OTelOverflowKindProductClass = "ProductClass"
+)
+
+const (
+	// OverflowAttributeKey marks the series of the measurements beyond the series limit, like the cardinality limit of the OTel specification.
+	OverflowAttributeKey attribute.Key = "otel.metric.overflow"
+)
+
+var (
+	ErrInvalidOverflowKind = errors.New("invalid overflow kind")
+)
+
+var (
+	overflowAttributes = attribute.NewSet(OverflowAttributeKey.Bool(true))
+)
+
+// otelSeriesLimits counts the series of each instrument, to limit their number, and remembers the series beyond the limit, to count each of them once. The series without measurements for longer than the TTL are forgotten and free their place.
+type otelSeriesLimits struct {
+	mutex     sync.Mutex
+	series    map[otelSeriesKey]time.Time
+	counts    map[string]int
+	overflows map[otelSeriesKey]time.Time
+	ttl       time.Duration
+	pruneTime time.Time
+}
+
+func newOTelSeriesLimits(ttl time.Duration) *otelSeriesLimits {
+	return &otelSeriesLimits{series: map[otelSeriesKey]time.Time{}, counts: map[string]int{}, overflows: map[otelSeriesKey]time.Time{}, ttl: ttl, pruneTime: time.Now()}
+}
+
+// allow reports whether the series is known or, if it is new, the instrument has fewer than maxSeries series. For a series beyond the limit, it also reports whether the series overflows for the first time within the TTL.
+func (l *otelSeriesLimits) allow(key otelSeriesKey, maxSeries int) (bool, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+
+	l.prune(now)
+
+	if _, ok := l.series[key]; ok {
+		l.series[key] = now
+
+		return true, false
+	}
+
+	if l.counts[key.name] >= maxSeries {
+		_, ok := l.overflows[key]
+
+		l.overflows[key] = now
+
+		return false, !ok
+	}
+
+	l.series[key] = now
+	l.counts[key.name]++
+
+	return true, false
+}
+
+// prune forgets the series and the overflow series without measurements for longer than the TTL. It scans the series at most once per half TTL.
+func (l *otelSeriesLimits) prune(now time.Time) {
+	if now.Sub(l.pruneTime) < l.ttl/2 {
+		return
+	}
+
+	l.pruneTime = now
+
+	for key, updateTime := range l.series {
+		if now.Sub(updateTime) > l.ttl {
+			delete(l.series, key)
+
+			l.counts[key.name]--
+		}
+	}
+
+	for key, updateTime := range l.overflows {
+		if now.Sub(updateTime) > l.ttl {
+			delete(l.overflows, key)
+		}
+	}
+}
+
+// overflowOTelAttributes returns the attributes of the measurements beyond the series limit. The attribute kind aggregates them into a single series, and the product class kind per OUI and ProductClass, without the SerialNumber. The product class kind applies to the overflow series only, DropSerialNumber aggregates all series of an instrument per OUI and ProductClass.
+func overflowOTelAttributes(overflowKind string, attributes attribute.Set) attribute.Set {
+	if overflowKind == OTelOverflowKindProductClass {
+		productClassAttributes, _ := attributes.Filter(func(keyValue attribute.KeyValue) bool {
+			return keyValue.Key != AttributeKey_SerialNumber
+		})
+
+		return attribute.NewSet(append(productClassAttributes.ToSlice(), OverflowAttributeKey.Bool(true))...)
+	}
+
+	return overflowAttributes
+}
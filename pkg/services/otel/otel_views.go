@@ -0,0 +1,38 @@
+package otel
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// NewOTelViews creates the views that keep only the allowed attributes of the instruments, and of their rate gauges, with AllowedAttributes. The overflow attribute is always allowed.
+func NewOTelViews(options *OTelCollectorServiceOptions) []sdkmetric.View {
+	views := []sdkmetric.View{}
+
+	for _, instrumentOptions := range options.Meter.Instruments {
+		if len(instrumentOptions.AllowedAttributes) == 0 {
+			continue
+		}
+
+		stream := sdkmetric.Stream{AttributeFilter: allowedOTelAttributesFilter(instrumentOptions.AllowedAttributes)}
+
+		views = append(views, sdkmetric.NewView(sdkmetric.Instrument{Name: instrumentOptions.Name, Scope: instrumentation.Scope{Name: options.Meter.Name}}, stream))
+
+		if instrumentOptions.RateName != "" {
+			views = append(views, sdkmetric.NewView(sdkmetric.Instrument{Name: instrumentOptions.RateName, Scope: instrumentation.Scope{Name: options.Meter.Name}}, stream))
+		}
+	}
+
+	return views
+}
+
+func allowedOTelAttributesFilter(allowedAttributes []string) attribute.Filter {
+	keys := make([]attribute.Key, 0, len(allowedAttributes)+1)
+
+	for _, allowedAttribute := range allowedAttributes {
+		keys = append(keys, attribute.Key(allowedAttribute))
+	}
+
+	return attribute.NewAllowKeysFilter(append(keys, OverflowAttributeKey)...)
+}